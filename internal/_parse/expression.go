@@ -15,8 +15,10 @@
 package parse
 
 import (
-	"fmt"
 	"strings"
+
+	"github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/preprocess"
 )
 
 func peekExpected(src *tokenReadPeeker, expected ...TokenType) (*Token, error) {
@@ -50,16 +52,39 @@ func nextExpected(src *tokenReadPeeker, expected ...TokenType) (*Token, error) {
 	for _, e := range expected {
 		s = append(s, e.String())
 	}
-	return nil, fmt.Errorf("parse: expected %s but %s", strings.Join(s, ","), tk.Type)
+	return nil, diag.Errorf(tk.Position(), "expected %s but %s", strings.Join(s, ","), tk.Type)
 }
 
 type Parser struct {
-	src    *tokenReadPeeker
-	errors []error
+	src   *tokenReadPeeker
+	diags diag.Collector
+}
+
+// NewParser returns a Parser reading the preprocessing tokens from src.
+func NewParser(src preprocess.PPTokenReader) *Parser {
+	p := &Parser{}
+	p.src = &tokenReadPeeker{
+		r: &tokenReader{
+			src:   src,
+			diags: &p.diags,
+		},
+	}
+	return p
 }
 
 func (p *Parser) appendError(err error) {
-	p.errors = append(p.errors, err)
+	p.diags.ReportError(err)
+}
+
+// Diagnostics returns the diagnostics reported while parsing.
+func (p *Parser) Diagnostics() []*diag.Diagnostic {
+	return p.diags.Diagnostics()
+}
+
+// Err returns an error of diag.List including all the errors reported while
+// parsing, or nil if there is no error.
+func (p *Parser) Err() error {
+	return p.diags.Err()
 }
 
 type Expression interface {
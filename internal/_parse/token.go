@@ -18,9 +18,11 @@ import (
 	"fmt"
 
 	"github.com/hajimehoshi/goc/internal/ctype"
+	"github.com/hajimehoshi/goc/internal/diag"
 	"github.com/hajimehoshi/goc/internal/io"
 	"github.com/hajimehoshi/goc/internal/lex"
 	"github.com/hajimehoshi/goc/internal/preprocess"
+	"github.com/hajimehoshi/goc/internal/srcpos"
 )
 
 type Token struct {
@@ -31,6 +33,14 @@ type Token struct {
 	StringValue  string
 
 	Name string
+
+	// Pos is the spelling location of the token.
+	Pos srcpos.Position
+
+	// ExpansionPos is the location of the outermost macro invocation that
+	// produced the token, or invalid if the token is not produced by macro
+	// expansion.
+	ExpansionPos srcpos.Position
 }
 
 type TokenReader interface {
@@ -39,6 +49,11 @@ type TokenReader interface {
 
 type tokenReader struct {
 	src preprocess.PPTokenReader
+
+	// diags is the collector of the diagnostics that do not stop the
+	// conversion, like the ones about the values of character constants. If
+	// diags is nil, such diagnostics are discarded.
+	diags *diag.Collector
 }
 
 func (t *tokenReader) NextToken() (*Token, error) {
@@ -47,6 +62,19 @@ func (t *tokenReader) NextToken() (*Token, error) {
 		return nil, err
 	}
 
+	tk, err := convertToken(p, t.diags)
+	if err != nil {
+		if _, ok := err.(*diag.Diagnostic); !ok {
+			err = diag.Errorf(p.Position(), "%s", err)
+		}
+		return nil, err
+	}
+	tk.Pos = p.Pos
+	tk.ExpansionPos = p.ExpansionPos
+	return tk, nil
+}
+
+func convertToken(p *preprocess.Token, diags *diag.Collector) (*Token, error) {
 	if p.Type < 128 && lex.IsSingleCharPunctuator(byte(p.Type)) {
 		return &Token{
 			Type: TokenType(p.Type),
@@ -168,12 +196,13 @@ func (t *tokenReader) NextToken() (*Token, error) {
 			IntegerValue: v,
 		}, nil
 	case preprocess.CharacterConstant:
+		v, d := p.CharacterValue()
+		if d != nil && diags != nil {
+			diags.Report(d)
+		}
 		return &Token{
-			Type: IntegerLiteral,
-			IntegerValue: ctype.IntegerValue{
-				Type:  ctype.Int,
-				Value: int64(p.Val[0]),
-			},
+			Type:         IntegerLiteral,
+			IntegerValue: v,
 		}, nil
 	case preprocess.StringLiteral:
 		return &Token{
@@ -185,7 +214,7 @@ func (t *tokenReader) NextToken() (*Token, error) {
 			Type: EOF,
 		}, nil
 	default:
-		return nil, fmt.Errorf("token: invalid token: %q", p.Raw)
+		return nil, diag.Errorf(p.Position(), "invalid token: %q", p.Raw)
 	}
 }
 
@@ -195,6 +224,16 @@ func Tokenize(src preprocess.PPTokenReader) TokenReader {
 	}
 }
 
+// Position returns the location where the token appears in the source: the
+// macro invocation for a token produced by macro expansion, or the spelling
+// location otherwise.
+func (t *Token) Position() srcpos.Position {
+	if t.ExpansionPos.IsValid() {
+		return t.ExpansionPos
+	}
+	return t.Pos
+}
+
 func (t *Token) String() string {
 	switch t.Type {
 	case IntegerLiteral:
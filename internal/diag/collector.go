@@ -0,0 +1,76 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag
+
+// Collector collects diagnostics so that processing can continue after
+// recoverable errors.
+//
+// The zero value is ready to use.
+type Collector struct {
+	diags  []*Diagnostic
+	errors int
+}
+
+// Report adds the diagnostic.
+func (c *Collector) Report(d *Diagnostic) {
+	c.diags = append(c.diags, d)
+	if d.IsError() {
+		c.errors++
+	}
+}
+
+// ReportError adds the error. If err is not a *Diagnostic, err is reported as
+// an error diagnostic without position.
+func (c *Collector) ReportError(err error) {
+	switch err := err.(type) {
+	case *Diagnostic:
+		c.Report(err)
+	case List:
+		for _, d := range err {
+			c.Report(d)
+		}
+	default:
+		c.Report(&Diagnostic{
+			Severity: Error,
+			Message:  err.Error(),
+		})
+	}
+}
+
+// Diagnostics returns all the reported diagnostics in the reported order.
+func (c *Collector) Diagnostics() []*Diagnostic {
+	return c.diags
+}
+
+// ErrorCount returns the number of the reported errors including fatal
+// errors.
+func (c *Collector) ErrorCount() int {
+	return c.errors
+}
+
+// Err returns a List of the errors if any errors are reported, or nil
+// otherwise. Warnings and notes are not included.
+func (c *Collector) Err() error {
+	if c.errors == 0 {
+		return nil
+	}
+	l := make(List, 0, c.errors)
+	for _, d := range c.diags {
+		if d.IsError() {
+			l = append(l, d)
+		}
+	}
+	return l
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+func TestCollector(t *testing.T) {
+	var c Collector
+	if err := c.Err(); err != nil {
+		t.Errorf("c.Err(): got: %v, want: nil", err)
+	}
+
+	p := srcpos.Position{Filename: "a.c", Line: 1, Column: 2}
+	c.Report(Warningf(p, "foo"))
+	if err := c.Err(); err != nil {
+		t.Errorf("c.Err(): got: %v, want: nil", err)
+	}
+
+	c.Report(Errorf(p, "bar"))
+	c.ReportError(fmt.Errorf("baz"))
+	c.ReportError(List{Notef(p, "qux"), Fatalf(p, "quux")})
+
+	if got, want := len(c.Diagnostics()), 5; got != want {
+		t.Errorf("len(c.Diagnostics()): got: %d, want: %d", got, want)
+	}
+	if got, want := c.ErrorCount(), 3; got != want {
+		t.Errorf("c.ErrorCount(): got: %d, want: %d", got, want)
+	}
+	want := `a.c:1:2: error: bar
+error: baz
+a.c:1:2: fatal error: quux`
+	if got := c.Err().Error(); got != want {
+		t.Errorf("c.Err().Error(): got: %q, want: %q", got, want)
+	}
+}
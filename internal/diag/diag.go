@@ -0,0 +1,150 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+type Severity int
+
+const (
+	Note Severity = iota
+	Warning
+	Error
+
+	// Fatal represents an error after which processing cannot continue.
+	Fatal
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Note:
+		return "note"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	case Fatal:
+		return "fatal error"
+	}
+	panic("not reached")
+}
+
+// Range represents a range in a source file. End is exclusive.
+// If End is invalid, the range points only to Start.
+type Range struct {
+	Start srcpos.Position
+	End   srcpos.Position
+}
+
+type FrameKind int
+
+const (
+	// IncludedFrom represents an #include directive that includes the file.
+	IncludedFrom FrameKind = iota
+
+	// ExpandedFrom represents a location in a macro definition or a macro
+	// argument where a token produced by macro expansion is spelled.
+	ExpandedFrom
+)
+
+// Frame represents an entry of an include or macro-expansion backtrace.
+type Frame struct {
+	Kind FrameKind
+	Pos  srcpos.Position
+
+	// Name is the macro name for ExpandedFrom. This can be empty.
+	Name string
+}
+
+// Diagnostic represents a diagnostic message.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+
+	// Range is the primary source range. Range.Start is the position where the
+	// diagnostic is reported.
+	Range Range
+
+	// Backtrace is the include and macro-expansion backtrace. The innermost
+	// frame comes first.
+	Backtrace []Frame
+
+	// Notes are notes attached to the diagnostic.
+	Notes []*Diagnostic
+}
+
+func newDiagnostic(severity Severity, pos srcpos.Position, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		Range: Range{
+			Start: pos,
+		},
+	}
+}
+
+// Errorf returns a new error diagnostic at pos.
+func Errorf(pos srcpos.Position, format string, args ...interface{}) *Diagnostic {
+	return newDiagnostic(Error, pos, format, args...)
+}
+
+// Warningf returns a new warning diagnostic at pos.
+func Warningf(pos srcpos.Position, format string, args ...interface{}) *Diagnostic {
+	return newDiagnostic(Warning, pos, format, args...)
+}
+
+// Notef returns a new note diagnostic at pos.
+func Notef(pos srcpos.Position, format string, args ...interface{}) *Diagnostic {
+	return newDiagnostic(Note, pos, format, args...)
+}
+
+// Fatalf returns a new fatal error diagnostic at pos.
+func Fatalf(pos srcpos.Position, format string, args ...interface{}) *Diagnostic {
+	return newDiagnostic(Fatal, pos, format, args...)
+}
+
+// Pos returns the position where the diagnostic is reported.
+func (d *Diagnostic) Pos() srcpos.Position {
+	return d.Range.Start
+}
+
+// IsError reports whether the diagnostic is an error or a fatal error.
+func (d *Diagnostic) IsError() bool {
+	return d.Severity >= Error
+}
+
+// Error returns a one-line message in the form of 'file:line:col: error: msg'.
+func (d *Diagnostic) Error() string {
+	if !d.Pos().IsValid() && d.Pos().Filename == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Pos(), d.Severity, d.Message)
+}
+
+// List is a list of diagnostics that is used as an error.
+type List []*Diagnostic
+
+func (l List) Error() string {
+	s := make([]string, 0, len(l))
+	for _, d := range l {
+		s = append(s, d.Error())
+	}
+	return strings.Join(s, "\n")
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+// Renderer renders diagnostics in the style of Clang:
+//
+//	In file included from main.c:1:
+//	foo.h:2:9: error: use of undeclared identifier 'y'
+//	int x = y + 1;
+//	        ^
+type Renderer struct {
+	// Source returns the content of the given file. If Source is nil or
+	// returns an error, source lines are not rendered.
+	Source func(filename string) ([]byte, error)
+
+	lines map[string][]string
+}
+
+// Render writes the diagnostic to w.
+func (r *Renderer) Render(w io.Writer, d *Diagnostic) error {
+	var buf bytes.Buffer
+	r.render(&buf, d)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// RenderAll writes the diagnostics to w in order.
+func (r *Renderer) RenderAll(w io.Writer, diags []*Diagnostic) error {
+	for _, d := range diags {
+		if err := r.Render(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Renderer) render(buf *bytes.Buffer, d *Diagnostic) {
+	first := true
+	for _, f := range d.Backtrace {
+		if f.Kind != IncludedFrom {
+			continue
+		}
+		if first {
+			fmt.Fprintf(buf, "In file included from %s:%d:\n", f.Pos.Filename, f.Pos.Line)
+			first = false
+			continue
+		}
+		fmt.Fprintf(buf, "                 from %s:%d:\n", f.Pos.Filename, f.Pos.Line)
+	}
+
+	buf.WriteString(d.Error())
+	buf.WriteString("\n")
+	r.renderSnippet(buf, d.Range)
+
+	// Like Clang, the macro expansions are noted from the outermost one.
+	for i := len(d.Backtrace) - 1; i >= 0; i-- {
+		f := d.Backtrace[i]
+		if f.Kind != ExpandedFrom {
+			continue
+		}
+		if f.Name != "" {
+			fmt.Fprintf(buf, "%s: note: expanded from macro '%s'\n", f.Pos, f.Name)
+		} else {
+			fmt.Fprintf(buf, "%s: note: expanded from here\n", f.Pos)
+		}
+		r.renderSnippet(buf, Range{Start: f.Pos})
+	}
+
+	for _, n := range d.Notes {
+		r.render(buf, n)
+	}
+}
+
+func (r *Renderer) line(pos srcpos.Position) (string, bool) {
+	if r.Source == nil || !pos.IsValid() {
+		return "", false
+	}
+	if r.lines == nil {
+		r.lines = map[string][]string{}
+	}
+	lines, ok := r.lines[pos.Filename]
+	if !ok {
+		src, err := r.Source(pos.Filename)
+		if err != nil {
+			lines = nil
+		} else {
+			lines = strings.Split(string(src), "\n")
+		}
+		r.lines[pos.Filename] = lines
+	}
+	if pos.Line > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[pos.Line-1], "\r"), true
+}
+
+func (r *Renderer) renderSnippet(buf *bytes.Buffer, rg Range) {
+	line, ok := r.line(rg.Start)
+	if !ok {
+		return
+	}
+
+	marks := make([]byte, len(line)+1)
+	for i := range marks {
+		marks[i] = ' '
+	}
+	start := rg.Start.Column - 1
+	end := start + 1
+	if rg.End.IsValid() && rg.End.Line == rg.Start.Line && rg.End.Column > rg.Start.Column {
+		end = rg.End.Column - 1
+	}
+	for i := start; i < end && i < len(marks); i++ {
+		if i >= 0 {
+			marks[i] = '~'
+		}
+	}
+	if 0 <= start && start < len(marks) {
+		marks[start] = '^'
+	}
+
+	// Keep tabs so that the marks are aligned with the source line.
+	for i := 0; i < len(line) && i < len(marks); i++ {
+		if line[i] == '\t' && marks[i] == ' ' {
+			marks[i] = '\t'
+		}
+	}
+
+	buf.WriteString(line)
+	buf.WriteString("\n")
+	buf.WriteString(strings.TrimRight(string(marks), " \t"))
+	buf.WriteString("\n")
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag_test
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+var files = map[string]string{
+	"main.c": "#include \"foo.h\"\nint y = x;\n",
+	"foo.h":  "#include \"bar.h\"\n",
+	"bar.h":  "#define FOO(a) (a + 1)\nint x = FOO(2) *  \"str\";\n\tint z = 0;\n",
+}
+
+func pos(filename string, line, column int) srcpos.Position {
+	return srcpos.Position{
+		Filename: filename,
+		Line:     line,
+		Column:   column,
+	}
+}
+
+func render(d *Diagnostic) {
+	r := &Renderer{
+		Source: func(filename string) ([]byte, error) {
+			src, ok := files[filename]
+			if !ok {
+				return nil, fmt.Errorf("not found: %s", filename)
+			}
+			return []byte(src), nil
+		},
+	}
+	if err := r.Render(os.Stdout, d); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func ExampleRenderer_simple() {
+	render(Errorf(pos("main.c", 2, 9), "use of undeclared identifier 'x'"))
+	// Output:
+	// main.c:2:9: error: use of undeclared identifier 'x'
+	// int y = x;
+	//         ^
+}
+
+func ExampleRenderer_noSource() {
+	render(Warningf(pos("unknown.c", 2, 9), "foo"))
+	render(Errorf(srcpos.Position{}, "bar"))
+	// Output:
+	// unknown.c:2:9: warning: foo
+	// error: bar
+}
+
+func ExampleRenderer_ranges() {
+	d := Errorf(pos("bar.h", 2, 19), "invalid operand to binary expression")
+	d.Range.End = pos("bar.h", 2, 24)
+	d.Backtrace = []Frame{
+		{Kind: IncludedFrom, Pos: pos("foo.h", 1, 1)},
+		{Kind: IncludedFrom, Pos: pos("main.c", 1, 1)},
+	}
+	render(d)
+	// Output:
+	// In file included from foo.h:1:
+	//                  from main.c:1:
+	// bar.h:2:19: error: invalid operand to binary expression
+	// int x = FOO(2) *  "str";
+	//                   ^~~~~
+}
+
+func ExampleRenderer_macro() {
+	d := Errorf(pos("bar.h", 2, 9), "something wrong")
+	d.Backtrace = []Frame{
+		{Kind: ExpandedFrom, Pos: pos("bar.h", 1, 20), Name: "FOO"},
+	}
+	d.Notes = []*Diagnostic{
+		Notef(pos("bar.h", 3, 6), "see here"),
+	}
+	render(d)
+	// Output:
+	// bar.h:2:9: error: something wrong
+	// int x = FOO(2) *  "str";
+	//         ^
+	// bar.h:1:20: note: expanded from macro 'FOO'
+	// #define FOO(a) (a + 1)
+	//                    ^
+	// bar.h:3:6: note: see here
+	// 	int z = 0;
+	// 	    ^
+}
@@ -14,11 +14,6 @@
 
 package lex
 
-import (
-	"fmt"
-	"io"
-)
-
 var escapedChars = map[byte]byte{
 	'a':  '\a',
 	'b':  '\b',
@@ -62,118 +57,3 @@ func hex(c byte) byte {
 	}
 	panic("not reached")
 }
-
-func ReadEscapedChar(src ByteReadPeeker) (byte, error) {
-	if err := shouldRead(src, '\\'); err != nil {
-		return 0, err
-	}
-
-	b, err := shouldReadByte(src)
-	if err != nil {
-		return 0, err
-	}
-
-	if ch, ok := escapedChars[b]; ok {
-		return ch, nil
-	}
-
-	// Hex
-	if b == 'x' {
-		bs, err := shouldPeek(src, 2)
-		if err != nil {
-			return 0, err
-		}
-		if !isHexDigit(bs[0]) {
-			return 0, fmt.Errorf("lex: non-hex character in escape sequence: %q", bs[0])
-		}
-		if !isHexDigit(bs[1]) {
-			return 0, fmt.Errorf("lex: non-hex character in escape sequence: %q", bs[1])
-		}
-		mustDiscard(src, 2)
-		return (hex(bs[0]) << 4) | hex(bs[1]), nil
-	}
-
-	// Oct
-	if isOctDigit(b) {
-		x := int(b - '0')
-
-		bs, err := src.Peek(1)
-		if err != nil && err != io.EOF {
-			return 0, err
-		}
-		if len(bs) < 1 {
-			return byte(x), nil
-		}
-		if !isOctDigit(bs[0]) {
-			return byte(x), nil
-		}
-		mustDiscard(src, 1)
-		x *= 8
-		x += int(bs[0] - '0')
-
-		bs, err = src.Peek(1)
-		if err != nil && err != io.EOF {
-			return 0, err
-		}
-		if len(bs) < 1 {
-			return byte(x), nil
-		}
-		if !isOctDigit(bs[0]) {
-			return byte(x), nil
-		}
-		mustDiscard(src, 1)
-		x *= 8
-		x += int(bs[0] - '0')
-		if x >= 256 {
-			return 0, fmt.Errorf("lex: octal escape value > 255: %d", x)
-		}
-		return byte(x), nil
-	}
-
-	if b == 'u' {
-		// TODO
-		return 0, fmt.Errorf("lex: \\uxxxx is not implemented yet")
-	}
-
-	if b == 'U' {
-		// TODO
-		return 0, fmt.Errorf("lex: \\Uxxxxxxxx is not implemented yet")
-	}
-
-	return 0, fmt.Errorf("lex: unknown escape sequence: %q", b)
-}
-
-func ReadChar(src ByteReadPeeker) (byte, error) {
-	if err := shouldRead(src, '\''); err != nil {
-		return 0, err
-	}
-
-	b, err := shouldPeekByte(src)
-	if err != nil {
-		return 0, err
-	}
-
-	v := byte(0)
-	if b != '\\' {
-		if b == '\r' || b == '\n' {
-			return 0, fmt.Errorf("lex: newline in character literal")
-		}
-		if b == '\'' {
-			return 0, fmt.Errorf("lex: empty character literal or unescaped ' in character literal")
-		}
-		mustDiscard(src, 1)
-		v = b
-	} else {
-		b, err := ReadEscapedChar(src)
-		if err != nil {
-			return 0, err
-		}
-		v = b
-	}
-
-	if err := shouldRead(src, '\''); err != nil {
-		return 0, err
-	}
-
-	return v, nil
-}
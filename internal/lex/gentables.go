@@ -0,0 +1,395 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build ignore
+// +build ignore
+
+// gentables generates tables.go from the Unicode Character Database.
+//
+// Usage:
+//
+//	go run gentables.go [-ucd url-or-dir] [-output file]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// unicodeVersion is the version of the Unicode Character Database that the
+// tables are generated from.
+const unicodeVersion = "14.0.0"
+
+var (
+	flagUCD    = flag.String("ucd", "https://www.unicode.org/Public/"+unicodeVersion+"/ucd", "URL or directory of the Unicode Character Database")
+	flagOutput = flag.String("output", "tables.go", "output file")
+)
+
+// open opens the file name of the Unicode Character Database.
+func open(name string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(*flagUCD, "http://") && !strings.HasPrefix(*flagUCD, "https://") {
+		return os.Open(filepath.Join(*flagUCD, name))
+	}
+	res, err := http.Get(strings.TrimSuffix(*flagUCD, "/") + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("gentables: %s: %s", name, res.Status)
+	}
+	return res.Body, nil
+}
+
+// parse calls f with the fields of each data line of the file name. Comments
+// and blank lines are skipped.
+func parse(name string, f func(fields []string) error) error {
+	r, err := open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if err := f(fields); err != nil {
+			return fmt.Errorf("gentables: %s: %v", name, err)
+		}
+	}
+	return s.Err()
+}
+
+func parseRune(s string) (rune, error) {
+	r, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return rune(r), nil
+}
+
+// parseRange parses a code point or a range of code points like 0041..005A.
+func parseRange(s string) (rune, rune, error) {
+	los, his := s, s
+	if i := strings.Index(s, ".."); i >= 0 {
+		los, his = s[:i], s[i+2:]
+	}
+	lo, err := parseRune(los)
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := parseRune(his)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lo, hi, nil
+}
+
+type runeRange struct {
+	lo rune
+	hi rune
+}
+
+type tables struct {
+	combiningClasses      map[rune]uint8
+	decompositions        map[rune][2]rune
+	compositionExclusions []rune
+	xidStart              []runeRange
+	xidContinue           []runeRange
+}
+
+func (t *tables) loadUnicodeData() error {
+	t.combiningClasses = map[rune]uint8{}
+	t.decompositions = map[rune][2]rune{}
+	return parse("UnicodeData.txt", func(fields []string) error {
+		if len(fields) < 6 {
+			return fmt.Errorf("too few fields: %q", fields)
+		}
+		r, err := parseRune(fields[0])
+		if err != nil {
+			return err
+		}
+		class, err := strconv.ParseUint(fields[3], 10, 8)
+		if err != nil {
+			return err
+		}
+		if class != 0 {
+			t.combiningClasses[r] = uint8(class)
+		}
+
+		// Compatibility decompositions begin with a tag like <compat>.
+		d := fields[5]
+		if d == "" || strings.HasPrefix(d, "<") {
+			return nil
+		}
+		var rs [2]rune
+		ds := strings.Fields(d)
+		if len(ds) > len(rs) {
+			return fmt.Errorf("too long decomposition: %q", d)
+		}
+		for i, s := range ds {
+			r, err := parseRune(s)
+			if err != nil {
+				return err
+			}
+			rs[i] = r
+		}
+		t.decompositions[r] = rs
+		return nil
+	})
+}
+
+func (t *tables) loadCompositionExclusions() error {
+	excluded := map[rune]bool{}
+	if err := parse("CompositionExclusions.txt", func(fields []string) error {
+		r, err := parseRune(fields[0])
+		if err != nil {
+			return err
+		}
+		excluded[r] = true
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// The non-starter decompositions are excluded from the compositions too,
+	// though they are not listed in CompositionExclusions.txt.
+	// "3.11 Normalization Forms" [Unicode]
+	for r, d := range t.decompositions {
+		if d[1] == 0 {
+			continue
+		}
+		if excluded[r] || t.combiningClasses[r] != 0 || t.combiningClasses[d[0]] != 0 {
+			t.compositionExclusions = append(t.compositionExclusions, r)
+		}
+	}
+	sort.Slice(t.compositionExclusions, func(i, j int) bool {
+		return t.compositionExclusions[i] < t.compositionExclusions[j]
+	})
+	return nil
+}
+
+func (t *tables) loadDerivedCoreProperties() error {
+	if err := parse("DerivedCoreProperties.txt", func(fields []string) error {
+		if len(fields) < 2 {
+			return fmt.Errorf("too few fields: %q", fields)
+		}
+		var ranges *[]runeRange
+		switch fields[1] {
+		case "XID_Start":
+			ranges = &t.xidStart
+		case "XID_Continue":
+			ranges = &t.xidContinue
+		default:
+			return nil
+		}
+		lo, hi, err := parseRange(fields[0])
+		if err != nil {
+			return err
+		}
+		*ranges = append(*ranges, runeRange{lo, hi})
+		return nil
+	}); err != nil {
+		return err
+	}
+	t.xidStart = mergeRanges(t.xidStart)
+	t.xidContinue = mergeRanges(t.xidContinue)
+	return nil
+}
+
+// mergeRanges sorts ranges and merges the adjacent ones.
+func mergeRanges(ranges []runeRange) []runeRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].lo < ranges[j].lo
+	})
+	var merged []runeRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && merged[n-1].hi+1 >= r.lo {
+			if merged[n-1].hi < r.hi {
+				merged[n-1].hi = r.hi
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func sortedRunes(m map[rune][2]rune) []rune {
+	rs := make([]rune, 0, len(m))
+	for r := range m {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i] < rs[j]
+	})
+	return rs
+}
+
+func (t *tables) write(w io.Writer) {
+	fmt.Fprintf(w, `// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by gentables.go. DO NOT EDIT.
+
+package lex
+
+// The tables in this file are derived from UnicodeData.txt,
+// CompositionExclusions.txt and DerivedCoreProperties.txt of the Unicode
+// Character Database %s.
+
+`, unicodeVersion)
+
+	fmt.Fprintln(w, "// combiningClasses is the ranges of the characters whose canonical combining")
+	fmt.Fprintln(w, "// classes are not 0.")
+	fmt.Fprintln(w, "var combiningClasses = []combiningClassRange{")
+	var classes []rune
+	for r := range t.combiningClasses {
+		classes = append(classes, r)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i] < classes[j]
+	})
+	for i := 0; i < len(classes); {
+		lo, class := classes[i], t.combiningClasses[classes[i]]
+		j := i + 1
+		for j < len(classes) && classes[j] == classes[j-1]+1 && t.combiningClasses[classes[j]] == class {
+			j++
+		}
+		fmt.Fprintf(w, "\t{0x%04x, 0x%04x, %d},\n", lo, classes[j-1], class)
+		i = j
+	}
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "// decompositions is the canonical decomposition mappings. The second element")
+	fmt.Fprintln(w, "// is 0 for a singleton decomposition. The mappings are not applied")
+	fmt.Fprintln(w, "// recursively, and Hangul syllables are not included.")
+	fmt.Fprintln(w, "var decompositions = map[rune][2]rune{")
+	for i, r := range sortedRunes(t.decompositions) {
+		switch {
+		case i%4 == 0:
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprint(w, "\t")
+		default:
+			fmt.Fprint(w, " ")
+		}
+		d := t.decompositions[r]
+		fmt.Fprintf(w, "0x%04x: {0x%04x, 0x%04x},", r, d[0], d[1])
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "// compositionExclusions is the characters that have canonical decompositions")
+	fmt.Fprintln(w, "// of two characters but are not primary composites. They include the")
+	fmt.Fprintln(w, "// non-starter decompositions.")
+	fmt.Fprintln(w, "var compositionExclusions = []rune{")
+	writeRunes(w, t.compositionExclusions)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "// xidStart is the ranges of the characters with the XID_Start property.")
+	fmt.Fprintln(w, "var xidStart = []runeRange{")
+	writeRanges(w, t.xidStart)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "// xidContinue is the ranges of the characters with the XID_Continue property.")
+	fmt.Fprintln(w, "var xidContinue = []runeRange{")
+	writeRanges(w, t.xidContinue)
+	fmt.Fprintln(w, "}")
+}
+
+func writeRunes(w io.Writer, rs []rune) {
+	for i, r := range rs {
+		switch {
+		case i%8 == 0:
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprint(w, "\t")
+		default:
+			fmt.Fprint(w, " ")
+		}
+		fmt.Fprintf(w, "0x%04x,", r)
+	}
+	fmt.Fprintln(w)
+}
+
+func writeRanges(w io.Writer, ranges []runeRange) {
+	for _, r := range ranges {
+		fmt.Fprintf(w, "\t{0x%04x, 0x%04x},\n", r.lo, r.hi)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	var t tables
+	if err := t.loadUnicodeData(); err != nil {
+		log.Fatal(err)
+	}
+	if err := t.loadCompositionExclusions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := t.loadDerivedCoreProperties(); err != nil {
+		log.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	t.write(&buf)
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*flagOutput, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
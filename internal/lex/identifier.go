@@ -17,6 +17,9 @@ package lex
 import (
 	"fmt"
 	"io"
+	"sort"
+	"unicode"
+	"unicode/utf8"
 )
 
 // IsWhitespace returns true if c is a whitespace char, otherwise false.
@@ -44,6 +47,42 @@ func IsNondigit(c byte) bool {
 	return false
 }
 
+// runeRange represents the range of the characters from lo to hi inclusive.
+type runeRange struct {
+	lo rune
+	hi rune
+}
+
+// inRanges reports whether r is in one of ranges, which are sorted.
+func inRanges(r rune, ranges []runeRange) bool {
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].hi >= r
+	})
+	return i < len(ranges) && ranges[i].lo <= r
+}
+
+// IsIdentifierStart returns true if r can be the first character of an
+// identifier, otherwise false. This is a nondigit character or a character
+// with the XID_Start property.
+// "6.4.2.1 General" [C23]
+func IsIdentifierStart(r rune) bool {
+	if r < utf8.RuneSelf {
+		return IsNondigit(byte(r))
+	}
+	return inRanges(r, xidStart)
+}
+
+// IsIdentifierContinue returns true if r can be a non-first character of an
+// identifier, otherwise false. This is a nondigit character, a digit
+// character or a character with the XID_Continue property.
+// "6.4.2.1 General" [C23]
+func IsIdentifierContinue(r rune) bool {
+	if r < utf8.RuneSelf {
+		return IsNondigit(byte(r)) || IsDigit(byte(r))
+	}
+	return inRanges(r, xidContinue)
+}
+
 // IsDigit returns true if c is digit character, otherwise false.
 // "6.4.2.1 General" [spec]
 func IsDigit(c byte) bool {
@@ -61,31 +100,156 @@ func IsSingleCharPunctuator(c byte) bool {
 	}
 }
 
-func ReadIdentifier(src ByteReadPeeker) (string, error) {
-	b, err := shouldReadByte(src)
-	if err != nil {
-		return "", err
+// ucnLength returns the number of the hexadecimal digits of the universal
+// character name beginning with \u or \U.
+func ucnLength(b byte) int {
+	if b == 'u' {
+		return 4
 	}
+	return 8
+}
 
-	if !IsNondigit(b) {
-		return "", fmt.Errorf("lex: expected nondigit but %q", string(rune(b)))
+// isValidUCN reports whether r can be designated by a universal character
+// name.
+//
+// "A universal character name shall not designate a code point where the
+// hexadecimal value is:
+// — less than 00A0 other than 0024 ($), 0040 (@), or 0060 (`);
+// — in the range D800 through DFFF inclusive; or
+// — greater than 10FFFF." [C23]
+func isValidUCN(r rune) bool {
+	if r < 0xa0 {
+		return r == '$' || r == '@' || r == '`'
+	}
+	if 0xd800 <= r && r <= 0xdfff {
+		return false
 	}
+	return r <= unicode.MaxRune
+}
 
-	r := []byte{b}
+// readUCNDigits reads the hexadecimal digits of the universal character name
+// after \u or \U, where b is u or U.
+//
+// "6.4.3 Universal character names" [spec]
+func readUCNDigits(src ByteReadPeeker, b byte) (rune, error) {
+	n := ucnLength(b)
+	bs, err := src.Peek(n)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	r := rune(0)
+	for i := 0; i < n; i++ {
+		if i >= len(bs) || !isHexDigit(bs[i]) {
+			return 0, fmt.Errorf("lex: incomplete universal character name \\%c%s", b, bs[:i])
+		}
+		r = r<<4 | rune(hex(bs[i]))
+	}
+	mustDiscard(src, n)
+	if !isValidUCN(r) {
+		return 0, fmt.Errorf("lex: \\%c%s is not a valid universal character", b, bs[:n])
+	}
+	return r, nil
+}
+
+// peekIdentifierChar returns the next character of src that can be a part of
+// an identifier, and its length in bytes. The character is either a byte, a
+// character encoded in UTF-8 or a universal character name. ucn indicates
+// whether the character is a universal character name. The value of a
+// universal character name is not validated.
+func peekIdentifierChar(src BytePeeker) (r rune, n int, ucn bool, err error) {
+	bs, err := src.Peek(10)
+	if err != nil && err != io.EOF {
+		return 0, 0, false, err
+	}
+	if len(bs) == 0 {
+		return 0, 0, false, nil
+	}
+	if bs[0] == '\\' && len(bs) >= 2 && (bs[1] == 'u' || bs[1] == 'U') {
+		l := ucnLength(bs[1])
+		if len(bs) < 2+l {
+			return '\\', 1, false, nil
+		}
+		r := rune(0)
+		for _, b := range bs[2 : 2+l] {
+			if !isHexDigit(b) {
+				return '\\', 1, false, nil
+			}
+			r = r<<4 | rune(hex(b))
+		}
+		return r, 2 + l, true, nil
+	}
+	if bs[0] < utf8.RuneSelf {
+		return rune(bs[0]), 1, false, nil
+	}
+	r, n = utf8.DecodeRune(bs)
+	return r, n, false, nil
+}
+
+// StartsWithIdentifier returns true if src begins with an identifier,
+// otherwise false. An identifier can begin with a universal character name
+// or a character encoded in UTF-8.
+func StartsWithIdentifier(src BytePeeker) bool {
+	r, n, ucn, err := peekIdentifierChar(src)
+	if err != nil || n == 0 {
+		return false
+	}
+	// An invalid universal character name is reported by ReadIdentifier.
+	if ucn {
+		return true
+	}
+	return IsIdentifierStart(r)
+}
+
+// ReadIdentifier reads an identifier. Universal character names in the
+// identifier are decoded, and the result is normalized to NFC so that the
+// identifiers with the same spelling in different forms are the same.
+//
+// "Each universal character name in an identifier shall designate a character
+// whose encoding in ISO/IEC 10646 falls into one of the ranges specified in
+// D.2." [spec]
+// The characters with the XID_Start and XID_Continue properties are allowed
+// as C23 does.
+//
+// An invalid universal character name is reported after the rest of the
+// identifier is read, so that the whole identifier is consumed.
+func ReadIdentifier(src ByteReadPeeker) (string, error) {
+	rs := []rune{}
+	var ucnErr error
 	for {
-		bs, err := src.Peek(1)
-		if err != nil && err != io.EOF {
+		r, n, ucn, err := peekIdentifierChar(src)
+		if err != nil {
 			return "", err
 		}
-		if len(bs) < 1 {
+		if n == 0 {
 			break
 		}
-		if !IsDigit(bs[0]) && !IsNondigit(bs[0]) {
+		if ucn {
+			bs, err := src.Peek(n)
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case ucnErr != nil:
+			case !isValidUCN(r):
+				ucnErr = fmt.Errorf("lex: %s is not a valid universal character", bs)
+			case len(rs) == 0 && !IsIdentifierStart(r):
+				ucnErr = fmt.Errorf("lex: universal character %s is not valid at the start of an identifier", bs)
+			case !IsIdentifierContinue(r):
+				ucnErr = fmt.Errorf("lex: universal character %s is not valid in an identifier", bs)
+			}
+		} else if len(rs) == 0 && !IsIdentifierStart(r) {
+			return "", fmt.Errorf("lex: expected nondigit but %q", string(r))
+		} else if !IsIdentifierContinue(r) {
 			break
 		}
-		mustDiscard(src, 1)
-		r = append(r, bs[0])
+		mustDiscard(src, n)
+		rs = append(rs, r)
 	}
-
-	return string(r), nil
+	if ucnErr != nil {
+		return "", ucnErr
+	}
+	if len(rs) == 0 {
+		return "", fmt.Errorf("lex: unexpected EOF")
+	}
+	return NormalizeNFC(string(rs)), nil
 }
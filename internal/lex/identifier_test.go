@@ -0,0 +1,64 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lex_test
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	. "github.com/hajimehoshi/goc/internal/lex"
+)
+
+func TestReadIdentifier(t *testing.T) {
+	cases := []struct {
+		In  string
+		Out string
+		Err bool
+	}{
+		{`a`, "a", false},
+		{`_a1+`, "_a1", false},
+		{"caf\u00e9", "caf\u00e9", false},
+		{"cafe\u0301", "caf\u00e9", false},
+		{`caf\u00e9`, "caf\u00e9", false},
+		{`cafe\u0301`, "caf\u00e9", false},
+		{`\U0001D49Cx`, "\U0001D49Cx", false},
+		{"\u5909\u65701", "\u5909\u65701", false},
+		{"a\u00b7", "a\u00b7", false},
+		{"a\u20ac", "a", false},
+		{`a\u`, "a", false},
+
+		{`1`, "", true},
+		{"\u20ac", "", true},
+		{"\U00011F04", "", true}, // Added in Unicode 15.0.0.
+		{``, "", true},
+		{`\u0041`, "", true},
+		{`\u0301`, "", true},
+		{`a\u00d7`, "", true},
+		{`a\ud800`, "", true},
+	}
+	for _, c := range cases {
+		got, err := ReadIdentifier(bufio.NewReader(bytes.NewReader([]byte(c.In))))
+		if err != nil && !c.Err {
+			t.Errorf("ReadIdentifier(%q) should not return error but did: %v", c.In, err)
+		}
+		if err == nil && c.Err {
+			t.Errorf("ReadIdentifier(%q) should return error but not", c.In)
+		}
+		if got != c.Out {
+			t.Errorf("ReadIdentifier(%q): got: %q, want: %q", c.In, got, c.Out)
+		}
+	}
+}
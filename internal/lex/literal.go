@@ -0,0 +1,206 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lex
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Char is a character in a character constant or a string literal.
+type Char struct {
+	// Value is the value of the character.
+	Value uint32
+
+	// CodeUnit indicates whether Value is a code unit of the encoding of the
+	// literal, given by an octal or hexadecimal escape sequence. Otherwise,
+	// Value is a code point.
+	CodeUnit bool
+}
+
+// readEscapeSequence reads an escape sequence.
+//
+// A hexadecimal escape sequence can have any number of digits, and the value
+// of an octal or hexadecimal escape sequence is not limited to a byte.
+//
+// "6.4.4.4 Character constants" [spec]
+func readEscapeSequence(src ByteReadPeeker) (Char, error) {
+	if err := shouldRead(src, '\\'); err != nil {
+		return Char{}, err
+	}
+
+	b, err := shouldReadByte(src)
+	if err != nil {
+		return Char{}, err
+	}
+
+	if ch, ok := escapedChars[b]; ok {
+		return Char{Value: uint32(ch)}, nil
+	}
+
+	// Hex
+	if b == 'x' {
+		// "Each octal or hexadecimal escape sequence is the longest sequence
+		// of characters that can constitute the escape sequence." [spec]
+		v := uint64(0)
+		n := 0
+		for {
+			bs, err := src.Peek(1)
+			if err != nil && err != io.EOF {
+				return Char{}, err
+			}
+			if len(bs) < 1 || !isHexDigit(bs[0]) {
+				break
+			}
+			mustDiscard(src, 1)
+			v = v<<4 | uint64(hex(bs[0]))
+			if v > 0xffffffff {
+				return Char{}, fmt.Errorf("lex: hex escape sequence out of range")
+			}
+			n++
+		}
+		if n == 0 {
+			return Char{}, fmt.Errorf("lex: \\x used with no following hex digits")
+		}
+		return Char{Value: uint32(v), CodeUnit: true}, nil
+	}
+
+	// Oct
+	if isOctDigit(b) {
+		v := uint32(b - '0')
+		for i := 0; i < 2; i++ {
+			bs, err := src.Peek(1)
+			if err != nil && err != io.EOF {
+				return Char{}, err
+			}
+			if len(bs) < 1 || !isOctDigit(bs[0]) {
+				break
+			}
+			mustDiscard(src, 1)
+			v = v*8 + uint32(bs[0]-'0')
+		}
+		return Char{Value: v, CodeUnit: true}, nil
+	}
+
+	if b == 'u' || b == 'U' {
+		r, err := readUCNDigits(src, b)
+		if err != nil {
+			return Char{}, err
+		}
+		return Char{Value: uint32(r)}, nil
+	}
+
+	return Char{}, fmt.Errorf("lex: unknown escape sequence: %q", b)
+}
+
+// readLiteralChar reads a character in a character constant or a string
+// literal. A source character is decoded as UTF-8, and a byte that is not a
+// part of a valid UTF-8 sequence is read as a code unit.
+func readLiteralChar(src ByteReadPeeker) (Char, error) {
+	b, err := shouldPeekByte(src)
+	if err != nil {
+		return Char{}, err
+	}
+	if b == '\\' {
+		return readEscapeSequence(src)
+	}
+	if b < utf8.RuneSelf {
+		mustDiscard(src, 1)
+		return Char{Value: uint32(b)}, nil
+	}
+	bs, err := src.Peek(utf8.UTFMax)
+	if err != nil && err != io.EOF {
+		return Char{}, err
+	}
+	r, n := utf8.DecodeRune(bs)
+	if r == utf8.RuneError && n <= 1 {
+		mustDiscard(src, 1)
+		return Char{Value: uint32(b), CodeUnit: true}, nil
+	}
+	mustDiscard(src, n)
+	return Char{Value: uint32(r)}, nil
+}
+
+// UnterminatedError is the error when a character constant or a string
+// literal is not terminated before the end of the line.
+type UnterminatedError struct {
+	// Quote is the quote character that begins the literal.
+	Quote byte
+}
+
+func (e *UnterminatedError) Error() string {
+	return fmt.Sprintf("lex: missing terminating %c character", e.Quote)
+}
+
+// ReadStringChars reads a string literal without an encoding prefix, and
+// returns its characters.
+func ReadStringChars(src ByteReadPeeker) ([]Char, error) {
+	if err := shouldRead(src, '"'); err != nil {
+		return nil, err
+	}
+
+	cs := []Char{}
+	for {
+		b, err := src.Peek(1)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(b) < 1 || b[0] == '\r' || b[0] == '\n' {
+			return nil, &UnterminatedError{Quote: '"'}
+		}
+		if b[0] == '"' {
+			mustDiscard(src, 1)
+			return cs, nil
+		}
+		c, err := readLiteralChar(src)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+}
+
+// ReadCharConstantChars reads a character constant without an encoding
+// prefix, and returns its characters. A character constant can have more
+// than one character.
+func ReadCharConstantChars(src ByteReadPeeker) ([]Char, error) {
+	if err := shouldRead(src, '\''); err != nil {
+		return nil, err
+	}
+
+	cs := []Char{}
+	for {
+		b, err := src.Peek(1)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(b) < 1 || b[0] == '\r' || b[0] == '\n' {
+			return nil, &UnterminatedError{Quote: '\''}
+		}
+		if b[0] == '\'' {
+			if len(cs) == 0 {
+				return nil, fmt.Errorf("lex: empty character literal or unescaped ' in character literal")
+			}
+			mustDiscard(src, 1)
+			return cs, nil
+		}
+		c, err := readLiteralChar(src)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lex_test
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "github.com/hajimehoshi/goc/internal/lex"
+)
+
+func TestReadStringChars(t *testing.T) {
+	cases := []struct {
+		In  string
+		Out []Char
+		Err bool
+	}{
+		{`""`, []Char{}, false},
+		{`"a\n"`, []Char{{Value: 'a'}, {Value: '\n'}}, false},
+		{`"é"`, []Char{{Value: 0xe9}}, false},
+		{"\"\xff\"", []Char{{Value: 0xff, CodeUnit: true}}, false},
+		{`"\xff"`, []Char{{Value: 0xff, CodeUnit: true}}, false},
+		{`"\x1234g"`, []Char{{Value: 0x1234, CodeUnit: true}, {Value: 'g'}}, false},
+		{`"\xffffffff"`, []Char{{Value: 0xffffffff, CodeUnit: true}}, false},
+		{`"\777"`, []Char{{Value: 0777, CodeUnit: true}}, false},
+		{`"\1234"`, []Char{{Value: 0123, CodeUnit: true}, {Value: '4'}}, false},
+		{`"\u00e9\U0001F600"`, []Char{{Value: 0xe9}, {Value: 0x1f600}}, false},
+		{`"\u0024"`, []Char{{Value: '$'}}, false},
+
+		{`"\x"`, nil, true},
+		{`"\x100000000"`, nil, true},
+		{`"\q"`, nil, true},
+		{`"\u00e"`, nil, true},
+		{`"\u0041"`, nil, true},
+		{`"\ud800"`, nil, true},
+		{`"\U00110000"`, nil, true},
+		{"\"\n\"", nil, true},
+		{`"a`, nil, true},
+	}
+	for _, c := range cases {
+		got, err := ReadStringChars(bufio.NewReader(bytes.NewReader([]byte(c.In))))
+		if err != nil && !c.Err {
+			t.Errorf("ReadStringChars(%q) should not return error but did: %v", c.In, err)
+		}
+		if err == nil && c.Err {
+			t.Errorf("ReadStringChars(%q) should return error but not", c.In)
+		}
+		if !reflect.DeepEqual(got, c.Out) {
+			t.Errorf("ReadStringChars(%q): got: %v, want: %v", c.In, got, c.Out)
+		}
+	}
+}
+
+func TestReadCharConstantChars(t *testing.T) {
+	cases := []struct {
+		In  string
+		Out []Char
+		Err bool
+	}{
+		{`'a'`, []Char{{Value: 'a'}}, false},
+		{`'\''`, []Char{{Value: '\''}}, false},
+		{`'あ'`, []Char{{Value: 0x3042}}, false},
+		{`'\x1234'`, []Char{{Value: 0x1234, CodeUnit: true}}, false},
+		{`'ab'`, []Char{{Value: 'a'}, {Value: 'b'}}, false},
+		{`'a\'\xff'`, []Char{{Value: 'a'}, {Value: '\''}, {Value: 0xff, CodeUnit: true}}, false},
+		{`'\n'`, []Char{{Value: '\n'}}, false},
+		{`'\\'`, []Char{{Value: '\\'}}, false},
+		{`'"'`, []Char{{Value: '"'}}, false},
+		{`'\0'`, []Char{{Value: 0, CodeUnit: true}}, false},
+		{`'\377'`, []Char{{Value: 0xff, CodeUnit: true}}, false},
+		{`'\778'`, []Char{{Value: 0x3f, CodeUnit: true}, {Value: '8'}}, false},
+		{`'é'`, []Char{{Value: 0xe9}}, false},
+
+		{`''`, nil, true},
+		{"'\n'", nil, true},
+		{"'a\n'", nil, true},
+		{`'a`, nil, true},
+		{`'''`, nil, true},
+		{`'\8'`, nil, true},
+		{"'\r'", nil, true},
+	}
+	for _, c := range cases {
+		got, err := ReadCharConstantChars(bufio.NewReader(bytes.NewReader([]byte(c.In))))
+		if err != nil && !c.Err {
+			t.Errorf("ReadCharConstantChars(%q) should not return error but did: %v", c.In, err)
+		}
+		if err == nil && c.Err {
+			t.Errorf("ReadCharConstantChars(%q) should return error but not", c.In)
+		}
+		if !reflect.DeepEqual(got, c.Out) {
+			t.Errorf("ReadCharConstantChars(%q): got: %v, want: %v", c.In, got, c.Out)
+		}
+	}
+}
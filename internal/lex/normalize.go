@@ -0,0 +1,167 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lex
+
+//go:generate go run gentables.go
+
+import (
+	"sort"
+)
+
+// combiningClassRange represents the range of the characters whose canonical
+// combining classes are the same.
+type combiningClassRange struct {
+	lo    rune
+	hi    rune
+	class uint8
+}
+
+// combiningClass returns the canonical combining class of r.
+func combiningClass(r rune) uint8 {
+	i := sort.Search(len(combiningClasses), func(i int) bool {
+		return combiningClasses[i].hi >= r
+	})
+	if i < len(combiningClasses) && combiningClasses[i].lo <= r {
+		return combiningClasses[i].class
+	}
+	return 0
+}
+
+// The constants for the Hangul syllables, which are decomposed and composed
+// algorithmically.
+const (
+	hangulSBase  = 0xac00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11a7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+// compositions is the map of the pairs of characters and their primary
+// composites.
+var compositions = map[[2]rune]rune{}
+
+func init() {
+	excluded := map[rune]bool{}
+	for _, r := range compositionExclusions {
+		excluded[r] = true
+	}
+	for r, d := range decompositions {
+		if d[1] == 0 || excluded[r] {
+			continue
+		}
+		compositions[d] = r
+	}
+}
+
+// decompose appends the full canonical decomposition of r to rs.
+func decompose(rs []rune, r rune) []rune {
+	if s := r - hangulSBase; 0 <= s && s < hangulSCount {
+		rs = append(rs, hangulLBase+s/hangulNCount, hangulVBase+s%hangulNCount/hangulTCount)
+		if t := s % hangulTCount; t != 0 {
+			rs = append(rs, hangulTBase+t)
+		}
+		return rs
+	}
+	d, ok := decompositions[r]
+	if !ok {
+		return append(rs, r)
+	}
+	rs = decompose(rs, d[0])
+	if d[1] != 0 {
+		rs = decompose(rs, d[1])
+	}
+	return rs
+}
+
+// compose returns the primary composite of a and b if exists.
+func compose(a, b rune) (rune, bool) {
+	if l, v := a-hangulLBase, b-hangulVBase; 0 <= l && l < hangulLCount && 0 <= v && v < hangulVCount {
+		return hangulSBase + (l*hangulVCount+v)*hangulTCount, true
+	}
+	if s, t := a-hangulSBase, b-hangulTBase; 0 <= s && s < hangulSCount && s%hangulTCount == 0 && 0 < t && t < hangulTCount {
+		return a + t, true
+	}
+	r, ok := compositions[[2]rune{a, b}]
+	return r, ok
+}
+
+// NormalizeNFC returns s in Unicode Normalization Form C.
+//
+// "5.3 Unicode Standard Annex #15, Unicode Normalization Forms" [UAX15]
+func NormalizeNFC(s string) string {
+	// Characters less than U+0300, whose UTF-8 sequences begin with bytes
+	// less than 0xcc, are never combined with each other.
+	quick := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0xcc {
+			quick = false
+			break
+		}
+	}
+	if quick {
+		return s
+	}
+
+	rs := []rune{}
+	for _, r := range s {
+		rs = decompose(rs, r)
+	}
+
+	// Canonical ordering: sort each sequence of non-starters by their
+	// combining classes.
+	for i := 0; i < len(rs); {
+		if combiningClass(rs[i]) == 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < len(rs) && combiningClass(rs[j]) != 0 {
+			j++
+		}
+		marks := rs[i:j]
+		sort.SliceStable(marks, func(a, b int) bool {
+			return combiningClass(marks[a]) < combiningClass(marks[b])
+		})
+		i = j
+	}
+
+	// Canonical composition: combine each character with the last starter
+	// unless it is blocked. As the non-starters are sorted, the character is
+	// blocked if the last character between them has the same or higher
+	// combining class.
+	out := make([]rune, 0, len(rs))
+	starter := -1
+	lastClass := uint8(0)
+	for _, r := range rs {
+		class := combiningClass(r)
+		if starter >= 0 && (starter == len(out)-1 || lastClass < class) {
+			if c, ok := compose(out[starter], r); ok {
+				out[starter] = c
+				continue
+			}
+		}
+		if class == 0 {
+			starter = len(out)
+		}
+		lastClass = class
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lex_test
+
+import (
+	"testing"
+
+	. "github.com/hajimehoshi/goc/internal/lex"
+)
+
+func TestNormalizeNFC(t *testing.T) {
+	cases := []struct {
+		In  string
+		Out string
+	}{
+		{"", ""},
+		{"abc", "abc"},
+		{"caf\u00e9", "caf\u00e9"},
+		{"cafe\u0301", "caf\u00e9"},
+		{"\u212b", "\u00c5"},
+		{"A\u030a", "\u00c5"},
+		{"a\u0323\u0302", "\u1ead"},
+		{"a\u0302\u0323", "\u1ead"},
+		{"\u1100\u1161\u11a8", "\uac01"},
+		{"\uac00\u11a8", "\uac01"},
+		{"\u0344", "\u0308\u0301"},
+		{"e\u0301\u0301", "\u00e9\u0301"},
+	}
+	for _, c := range cases {
+		if got := NormalizeNFC(c.In); got != c.Out {
+			t.Errorf("NormalizeNFC(%+q): got: %+q, want: %+q", c.In, got, c.Out)
+		}
+	}
+}
@@ -15,8 +15,11 @@
 package lex
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"strings"
 
 	"github.com/hajimehoshi/goc/internal/ctype"
 )
@@ -46,27 +49,62 @@ func ReadIntegerSuffix(src ByteReadPeeker) (IntegerSuffix, error) {
 		break
 	}
 
-	switch s {
-	case "":
+	// "integer-suffix:
+	//     unsigned-suffix long-suffix_opt
+	//     unsigned-suffix long-long-suffix
+	//     long-suffix unsigned-suffix_opt
+	//     long-long-suffix unsigned-suffix_opt" [spec]
+	rest := s
+	unsigned := false
+	long := 0
+	if len(rest) > 0 && (rest[0] == 'u' || rest[0] == 'U') {
+		unsigned = true
+		rest = rest[1:]
+	}
+	switch {
+	case strings.HasPrefix(rest, "ll"), strings.HasPrefix(rest, "LL"):
+		long = 2
+		rest = rest[2:]
+	case strings.HasPrefix(rest, "l"), strings.HasPrefix(rest, "L"):
+		long = 1
+		rest = rest[1:]
+	}
+	if !unsigned && len(rest) > 0 && (rest[0] == 'u' || rest[0] == 'U') {
+		unsigned = true
+		rest = rest[1:]
+	}
+	if rest != "" {
+		return 0, fmt.Errorf("lex: unexpected suffix %q", s)
+	}
+	mustDiscard(src, len(s))
+
+	switch {
+	case !unsigned && long == 0:
 		return IntegerSuffixNone, nil
-	case "l", "L":
-		mustDiscard(src, 1)
+	case !unsigned && long == 1:
 		return IntegerSuffixL, nil
-	case "ll", "LL":
-		mustDiscard(src, 2)
+	case !unsigned && long == 2:
 		return IntegerSuffixLL, nil
-	case "u", "U":
-		mustDiscard(src, 1)
+	case unsigned && long == 0:
 		return IntegerSuffixU, nil
-	case "ul", "UL":
-		mustDiscard(src, 2)
+	case unsigned && long == 1:
 		return IntegerSuffixUL, nil
-	case "ull", "ULL":
-		mustDiscard(src, 3)
+	default:
 		return IntegerSuffixULL, nil
 	}
+}
 
-	return 0, fmt.Errorf("lex: unexpected suffix %q", s)
+// ErrIntegerTooLarge is returned by ReadNumber when the value of the integer
+// constant cannot be represented in 64 bits.
+var ErrIntegerTooLarge = errors.New("lex: integer constant is too large for its type")
+
+// accumulateDigit returns v*base+d, or ErrIntegerTooLarge if the result
+// overflows.
+func accumulateDigit(v uint64, base uint64, d uint64) (uint64, error) {
+	if v > (math.MaxUint64-d)/base {
+		return 0, ErrIntegerTooLarge
+	}
+	return v*base + d, nil
 }
 
 func ReadNumber(src ByteReadPeeker) (ctype.IntegerValue, error) {
@@ -81,7 +119,7 @@ func ReadNumber(src ByteReadPeeker) (ctype.IntegerValue, error) {
 		return ctype.IntegerValue{}, fmt.Errorf("lex: non-digit character")
 	}
 
-	v := int64(0)
+	u := uint64(0)
 
 	if b == '0' {
 		bs, err := src.Peek(1)
@@ -108,8 +146,9 @@ func ReadNumber(src ByteReadPeeker) (ctype.IntegerValue, error) {
 					break
 				}
 				mustDiscard(src, 1)
-				v *= 16
-				v += int64(hex(bs[0]))
+				if u, err = accumulateDigit(u, 16, uint64(hex(bs[0]))); err != nil {
+					return ctype.IntegerValue{}, err
+				}
 			}
 		}
 		if IsDigit(bs[0]) {
@@ -128,12 +167,13 @@ func ReadNumber(src ByteReadPeeker) (ctype.IntegerValue, error) {
 					return ctype.IntegerValue{}, fmt.Errorf("lex: malformed octal constant")
 				}
 				mustDiscard(src, 1)
-				v *= 8
-				v += int64(bs[0] - '0')
+				if u, err = accumulateDigit(u, 8, uint64(bs[0]-'0')); err != nil {
+					return ctype.IntegerValue{}, err
+				}
 			}
 		}
 	} else {
-		v = int64(b - '0')
+		u = uint64(b - '0')
 		for {
 			bs, err := src.Peek(1)
 			if err != nil && err != io.EOF {
@@ -142,25 +182,27 @@ func ReadNumber(src ByteReadPeeker) (ctype.IntegerValue, error) {
 			if len(bs) < 1 {
 				return ctype.IntegerValue{
 					Type:  ctype.Int,
-					Value: v,
+					Value: int64(u),
 				}, nil
 			}
 			if !IsDigit(bs[0]) {
 				break
 			}
 			mustDiscard(src, 1)
-			v *= 10
-			v += int64(bs[0] - '0')
+			if u, err = accumulateDigit(u, 10, uint64(bs[0]-'0')); err != nil {
+				return ctype.IntegerValue{}, err
+			}
 		}
 	}
 
+	v := int64(u)
 	s, err := ReadIntegerSuffix(src)
 	if err != nil {
 		return ctype.IntegerValue{}, err
 	}
 	switch s {
 	case IntegerSuffixNone:
-		if v >= 0x80000000 {
+		if u >= 0x80000000 {
 			return ctype.IntegerValue{
 				Type:  ctype.LongLong,
 				Value: v,
@@ -171,7 +213,7 @@ func ReadNumber(src ByteReadPeeker) (ctype.IntegerValue, error) {
 			Value: v,
 		}, nil
 	case IntegerSuffixL:
-		if v >= 0x80000000 {
+		if u >= 0x80000000 {
 			return ctype.IntegerValue{
 				Type:  ctype.LongLong,
 				Value: v,
@@ -187,7 +229,7 @@ func ReadNumber(src ByteReadPeeker) (ctype.IntegerValue, error) {
 			Value: v,
 		}, nil
 	case IntegerSuffixU:
-		if v >= 0x100000000 {
+		if u >= 0x100000000 {
 			return ctype.IntegerValue{
 				Type:  ctype.ULongLong,
 				Value: v,
@@ -198,7 +240,7 @@ func ReadNumber(src ByteReadPeeker) (ctype.IntegerValue, error) {
 			Value: v,
 		}, nil
 	case IntegerSuffixUL:
-		if v >= 0x100000000 {
+		if u >= 0x100000000 {
 			return ctype.IntegerValue{
 				Type:  ctype.ULongLong,
 				Value: v,
@@ -46,11 +46,27 @@ func TestReadIntegerSuffix(t *testing.T) {
 		{`u*`, IntegerSuffixU, false},
 		{`ul/`, IntegerSuffixUL, false},
 		{`ull `, IntegerSuffixULL, false},
+		{`uL`, IntegerSuffixUL, false},
+		{`Ul`, IntegerSuffixUL, false},
+		{`uLL`, IntegerSuffixULL, false},
+		{`Ull`, IntegerSuffixULL, false},
+		{`lu`, IntegerSuffixUL, false},
+		{`LU`, IntegerSuffixUL, false},
+		{`lU`, IntegerSuffixUL, false},
+		{`Lu`, IntegerSuffixUL, false},
+		{`llu`, IntegerSuffixULL, false},
+		{`LLU`, IntegerSuffixULL, false},
+		{`llU`, IntegerSuffixULL, false},
+		{`LLu`, IntegerSuffixULL, false},
+		{`lu+`, IntegerSuffixUL, false},
 		{`lL`, 0, true},
 		{`Ll`, 0, true},
 		{`ulL`, 0, true},
 		{`uLl`, 0, true},
-		{`Ull`, 0, true},
+		{`lLu`, 0, true},
+		{`uu`, 0, true},
+		{`ulu`, 0, true},
+		{`lul`, 0, true},
 		{`la`, 0, true},
 		{`ZZ`, 0, true},
 	}
@@ -85,6 +101,9 @@ func TestReadNumber(t *testing.T) {
 		{`16777216ULL`, ctype.IntegerValue{Type: ctype.ULongLong, Value: 16777216}, false},
 		{`42+`, ctype.IntegerValue{Type: ctype.Int, Value: 42}, false},
 		{`141421356ul-`, ctype.IntegerValue{Type: ctype.ULong, Value: 141421356}, false},
+		{`141421356lu-`, ctype.IntegerValue{Type: ctype.ULong, Value: 141421356}, false},
+		{`16777216LLU`, ctype.IntegerValue{Type: ctype.ULongLong, Value: 16777216}, false},
+		{`16777216Ull`, ctype.IntegerValue{Type: ctype.ULongLong, Value: 16777216}, false},
 
 		// Oct
 		{`0377`, ctype.IntegerValue{Type: ctype.Int, Value: 255}, false},
@@ -132,6 +151,13 @@ func TestReadNumber(t *testing.T) {
 		{`0x7ffffffffffffffful`, ctype.IntegerValue{Type: ctype.ULongLong, Value: 0x7fffffffffffffff}, false},
 		{`0x7fffffffffffffffull`, ctype.IntegerValue{Type: ctype.ULongLong, Value: 0x7fffffffffffffff}, false},
 
+		{`0xffffffffffffffffu`, ctype.IntegerValue{Type: ctype.ULongLong, Value: -1}, false},
+		{`18446744073709551615u`, ctype.IntegerValue{Type: ctype.ULongLong, Value: -1}, false},
+
+		{`0x10000000000000000`, ctype.IntegerValue{}, true},
+		{`18446744073709551616u`, ctype.IntegerValue{}, true},
+		{`99999999999999999999`, ctype.IntegerValue{}, true},
+		{`02000000000000000000000`, ctype.IntegerValue{}, true},
 		{`08`, ctype.IntegerValue{}, true},
 		{`x`, ctype.IntegerValue{}, true},
 	}
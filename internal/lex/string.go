@@ -18,38 +18,25 @@ import (
 	"fmt"
 )
 
+// ReadString reads a string literal without an encoding prefix, and returns
+// its value encoded in UTF-8. An octal or hexadecimal escape sequence is a
+// byte.
 func ReadString(src ByteReadPeeker) (string, error) {
-	if err := shouldRead(src, '"'); err != nil {
+	cs, err := ReadStringChars(src)
+	if err != nil {
 		return "", err
 	}
 
 	bs := []byte{}
-loop:
-	for {
-		b, err := shouldPeekByte(src)
-		if err != nil {
-			return "", err
+	for _, c := range cs {
+		if !c.CodeUnit {
+			bs = append(bs, string(rune(c.Value))...)
+			continue
 		}
-		switch b {
-		case '"':
-			mustDiscard(src, 1)
-			break loop
-		case '\\':
-			b, err := ReadEscapedChar(src)
-			if err != nil {
-				return "", err
-			}
-			if b > 255 {
-				return "", fmt.Errorf("lex: not implemented")
-			}
-			bs = append(bs, byte(b))
-			continue loop
-		case '\r', '\n':
-			return "", fmt.Errorf("lex: newline in string")
+		if c.Value > 0xff {
+			return "", fmt.Errorf("lex: escape sequence out of range")
 		}
-		mustDiscard(src, 1)
-		bs = append(bs, b)
+		bs = append(bs, byte(c.Value))
 	}
-
 	return string(bs), nil
 }
@@ -41,11 +41,24 @@ func TestReadString(t *testing.T) {
 		{`"\00"`, string(0), false},
 		{`"\08"`, string(0) + "8", false},
 		{`"\xff"`, string([]byte{0xff}), false},
+		{`"\t\"\?"`, "\t\"?", false},
+		{`"\x12\x20"`, "\x12 ", false},
+		{`"\xf"`, "\x0f", false},
+		{`"\x0g"`, "\x00g", false},
+		{`"\07\377"`, "\x07\xff", false},
+		{`"\778"`, "?8", false},
+		{`"\u00e9\U0001F600"`, "\u00e9\U0001F600", false},
 
 		{"\"\n\"", "", true},
 		{`"`, "", true},
 		{`"'`, "", true},
 		{`"a`, "", true},
+		{`"\x"`, "", true},
+		{`"\x100"`, "", true},
+		{`"\400"`, "", true},
+		{`"\8"`, "", true},
+		{`"\u"`, "", true},
+		{`"\ud800"`, "", true},
 	}
 	for _, c := range cases {
 		got, err := ReadString(bufio.NewReader(bytes.NewReader([]byte(c.In))))
@@ -0,0 +1,162 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+// commandLineFilename is the file name of the tokens given by the options.
+const commandLineFilename = "<command line>"
+
+// MacroOption represents an option that defines or undefines a macro, like -D
+// or -U.
+type MacroOption struct {
+	// Undef indicates whether the option undefines the macro like -U.
+	Undef bool
+
+	// Macro is the operand of the option. For -D, this is NAME, NAME=VALUE or
+	// NAME(PARAMS)=VALUE, and NAME without = is defined as 1. For -U, this is
+	// NAME. Macro must not have new-lines or end with a backslash.
+	Macro string
+}
+
+// Define returns an option like -D.
+func Define(macro string) MacroOption {
+	return MacroOption{
+		Macro: macro,
+	}
+}
+
+// Undefine returns an option like -U.
+func Undefine(name string) MacroOption {
+	return MacroOption{
+		Undef: true,
+		Macro: name,
+	}
+}
+
+// directive returns the directive line equivalent to the option. An error is
+// returned if the option cannot be a single directive line.
+func (m MacroOption) directive() (string, error) {
+	// The option must not continue to the next line.
+	if strings.ContainsAny(m.Macro, "\r\n") {
+		return "", fmt.Errorf("new-line in macro option %q", m.Macro)
+	}
+	if strings.HasSuffix(m.Macro, `\`) {
+		return "", fmt.Errorf("backslash at end of macro option %q", m.Macro)
+	}
+	if m.Undef {
+		return "#undef " + m.Macro, nil
+	}
+	if i := strings.Index(m.Macro, "="); i >= 0 {
+		return "#define " + m.Macro[:i] + " " + m.Macro[i+1:], nil
+	}
+	return "#define " + m.Macro + " 1", nil
+}
+
+// includeDirective returns the #include directive line of the forced include
+// of path. An error is returned if path cannot be a header name.
+func includeDirective(path string) (string, error) {
+	if strings.ContainsAny(path, "\"\r\n") {
+		return "", fmt.Errorf("invalid character in forced include %q", path)
+	}
+	return `#include "` + path + `"`, nil
+}
+
+// newCommandLine returns a preprocessor of the command-line options, which
+// shares the states with p. The command-line options are processed as if
+// they are a file included before the main file, where each option is a line.
+//
+// Like GCC, all the macro options are processed before the forced includes.
+func (p *preprocessor) newCommandLine(opts *Options) (*preprocessor, error) {
+	if len(opts.MacroOptions) == 0 && len(opts.ForceIncludes) == 0 {
+		return nil, nil
+	}
+	c := &preprocessor{
+		path:     commandLineFilename,
+		files:    p.files,
+		resolver: p.resolver,
+		macros:   p.macros,
+		diags:    p.diags,
+		dirIndex: -1,
+
+		pragmas:            p.pragmas,
+		warnUnknownPragmas: p.warnUnknownPragmas,
+		keepPragmas:        p.keepPragmas,
+		pragmaState:        p.pragmaState,
+		maxIncludeDepth:    p.maxIncludeDepth,
+		observer:           p.observer,
+		deps:               p.deps,
+		attributes:         p.attributes,
+		builtins:           p.builtins,
+	}
+	var lines []string
+	var errs []error
+	for _, m := range opts.MacroOptions {
+		l, err := m.directive()
+		lines = append(lines, l)
+		errs = append(errs, err)
+	}
+	for _, path := range opts.ForceIncludes {
+		l, err := includeDirective(path)
+		lines = append(lines, l)
+		errs = append(errs, err)
+	}
+
+	tokens := []*Token{}
+	for i, l := range lines {
+		if errs[i] != nil {
+			pos := srcpos.Position{
+				Filename: commandLineFilename,
+				Line:     i + 1,
+			}
+			if err := c.report(diag.Errorf(pos, "%s", errs[i])); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Each option is tokenized separately so that it cannot affect the
+		// other options, e.g., by an unterminated comment. The new-lines
+		// before the option keep its line number.
+		ts, err := Tokenize([]byte(strings.Repeat("\n", i)+l+"\n"), commandLineFilename)
+		if l, ok := err.(diag.List); ok {
+			for _, d := range l {
+				if err := c.report(d); err != nil {
+					return nil, err
+				}
+			}
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range ts {
+			if t.Pos.Line > i {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	c.file = newFile(tokens)
+	c.src = &ppTokenBufReader{
+		tokens: tokens,
+		report: c.report,
+	}
+	return c, nil
+}
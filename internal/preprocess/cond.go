@@ -0,0 +1,436 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+// condition represents an if-section that is being processed.
+type condition struct {
+	// directive is the name of the directive that begins the if-section.
+	directive string
+
+	// pos is the position of the directive that begins the if-section.
+	pos srcpos.Position
+
+	// taken indicates whether one of the groups in the if-section has already
+	// been taken.
+	taken bool
+
+	// elseSeen indicates whether #else has already been seen.
+	elseSeen bool
+
+	// skipped indicates whether the whole if-section is in a skipped group.
+	skipped bool
+}
+
+// evalDefined reads the operand of the defined unary operator and returns the
+// pp-number 1 or 0.
+func (p *preprocessor) evalDefined(defined *Token) (*Token, error) {
+	t, err := p.nextUnexpanded()
+	if err != nil {
+		return nil, err
+	}
+	paren := false
+	if t.Type == '(' {
+		paren = true
+		t, err = p.nextUnexpanded()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.Type != Identifier {
+		return nil, errorAt(defined, "operator \"defined\" requires an identifier")
+	}
+	name := t.Val
+	if paren {
+		t, err := p.nextUnexpanded()
+		if err != nil {
+			return nil, err
+		}
+		if t.Type != ')' {
+			return nil, errorAt(t, "missing ')' after \"defined\"")
+		}
+	}
+
+	v := 0
+	if p.isDefined(name) {
+		v = 1
+	}
+	return operatorResult(defined, v), nil
+}
+
+// operatorResult returns the pp-number v as the result of the operator op.
+func operatorResult(op *Token, v int) *Token {
+	t := newPPNumber(strconv.Itoa(v))
+	t.Pos = op.Pos
+	t.ExpansionPos = op.ExpansionPos
+	t.expansions = op.expansions
+	return t
+}
+
+// hasOperators is the operators that are available in the controlling
+// expressions of #if and #elif in addition to defined. They are regarded as
+// defined macros so that their availability can be tested by #ifdef.
+var hasOperators = map[string]struct{}{
+	"__has_include":      {},
+	"__has_include_next": {},
+	"__has_attribute":    {},
+	"__has_builtin":      {},
+}
+
+// isDefined reports whether name is defined as a macro.
+func (p *preprocessor) isDefined(name string) bool {
+	if _, ok := p.macros[name]; ok {
+		return true
+	}
+	_, ok := hasOperators[name]
+	return ok
+}
+
+// readOperand reads the parenthesized operand of the operator op from e
+// without macro expansion.
+func readOperand(e *preprocessor, op *Token) ([]*Token, error) {
+	t, err := e.nextUnexpanded()
+	if err != nil {
+		return nil, err
+	}
+	if t.Type != '(' {
+		return nil, errorAt(op, "missing '(' after \"%s\"", op.Val)
+	}
+	ts := []*Token{}
+	level := 0
+	for {
+		t, err := e.nextUnexpanded()
+		if err != nil {
+			return nil, err
+		}
+		switch t.Type {
+		case EOF:
+			return nil, errorAt(op, "missing ')' after \"%s\"", op.Val)
+		case '(':
+			level++
+		case ')':
+			if level == 0 {
+				return ts, nil
+			}
+			level--
+		}
+		ts = append(ts, t)
+	}
+}
+
+// evalHasInclude reads the operand of __has_include or __has_include_next
+// from e, and returns the pp-number 1 if the header is found, or 0
+// otherwise.
+//
+// "6.10.1 Conditional inclusion" [C23]
+func (p *preprocessor) evalHasInclude(e *preprocessor, op *Token) (*Token, error) {
+	ts, err := readOperand(e, op)
+	if err != nil {
+		return nil, err
+	}
+	// The operand is macro-expanded unless it is a header name.
+	if len(ts) > 0 && ts[0].Type != StringLiteral && ts[0].Type != '<' {
+		ts, err = p.expandLine(ts, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	h, rest, err := headerName(op, op.Val, ts)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errorAt(rest[0], "extra tokens in \"%s\"", op.Val)
+	}
+
+	next := op.Val == "__has_include_next" && len(p.includedFrom) > 0
+	_, _, ok := p.resolver.resolve(h.Val, h.Raw[0] == '"', p.path, next, p.dirIndex)
+	v := 0
+	if ok {
+		v = 1
+	}
+	return operatorResult(op, v), nil
+}
+
+// evalHasFeature reads the operand of __has_attribute or __has_builtin from
+// e, and returns the pp-number representing whether the attribute or the
+// builtin is supported.
+//
+// For __has_attribute, the result is the version of the attribute like
+// 202311, or 0 if it is not supported. For __has_builtin, the result is 1 or
+// 0.
+func (p *preprocessor) evalHasFeature(e *preprocessor, op *Token) (*Token, error) {
+	ts, err := readOperand(e, op)
+	if err != nil {
+		return nil, err
+	}
+	if len(ts) == 0 || ts[0].Type != Identifier {
+		return nil, errorAt(op, "operator \"%s\" requires an identifier", op.Val)
+	}
+
+	v := 0
+	switch op.Val {
+	case "__has_attribute":
+		// An attribute can have a prefix like gnu::, and the names with
+		// surrounding double underscores like __noreturn__ are equivalent to
+		// the ones without them.
+		name := ""
+		for _, t := range ts {
+			s := t.Raw
+			if t.Type == Identifier && len(s) > 4 && strings.HasPrefix(s, "__") && strings.HasSuffix(s, "__") {
+				s = s[2 : len(s)-2]
+			}
+			name += s
+		}
+		v = p.attributes[name]
+	case "__has_builtin":
+		if len(ts) > 1 {
+			return nil, errorAt(ts[1], "extra tokens in \"%s\"", op.Val)
+		}
+		if p.builtins[ts[0].Val] {
+			v = 1
+		}
+	default:
+		panic("not reached")
+	}
+	return operatorResult(op, v), nil
+}
+
+// evalCondition evaluates the tokens of the controlling expression of #if or
+// #elif. dir is the directive name token.
+//
+// A recoverable error is reported and the condition is treated as false.
+func (p *preprocessor) evalCondition(dir *Token, tokens []*Token) (bool, error) {
+	// "If the token defined is generated as a result of this replacement
+	// process ..., the behavior is undefined." [spec]
+	// Such defined is treated as an operator as other implementations do.
+	ts, err := p.expandLine(tokens, true)
+	if err == nil {
+		var v bool
+		v, err = evalExpression(dir, ts, p.report)
+		if err == nil {
+			return v, nil
+		}
+	}
+	if err := p.report(err); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// conditionError returns an error at the directive beginning with hash, with
+// a note pointing to the beginning of the if-section c.
+func conditionError(hash *Token, c *condition, format string, args ...interface{}) error {
+	d := errorAt(hash, format, args...)
+	d.Notes = append(d.Notes, diag.Notef(c.pos, "the if-section began with #%s here", c.directive))
+	return d
+}
+
+// reportUnterminated reports the if-sections that are not terminated at the
+// end of the file.
+func (p *preprocessor) reportUnterminated() {
+	for _, c := range p.conds {
+		d := diag.Errorf(c.pos, "unterminated #%s", c.directive)
+		p.report(d)
+	}
+	p.conds = nil
+}
+
+// processIf processes #if, #ifdef or #ifndef in a group that is not skipped.
+func (p *preprocessor) processIf(hash *Token, dir *Token) error {
+	c := &condition{
+		directive: dir.Val,
+		pos:       hash.Pos,
+	}
+
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	switch dir.Val {
+	case "if":
+		v, err := p.evalCondition(dir, line)
+		if err != nil {
+			return err
+		}
+		c.taken = v
+	case "ifdef", "ifndef":
+		switch {
+		case len(line) == 0 || line[0].Type != Identifier:
+			err = errorAt(dir, "no macro name given in #%s directive", dir.Val)
+		case len(line) > 1:
+			err = errorAt(line[1], "extra tokens at end of #%s directive", dir.Val)
+		}
+		if err != nil {
+			if err := p.report(err); err != nil {
+				return err
+			}
+		}
+		if len(line) > 0 {
+			ok := p.isDefined(line[0].Val)
+			c.taken = ok == (dir.Val == "ifdef")
+		}
+	default:
+		panic("not reached")
+	}
+
+	p.conds = append(p.conds, c)
+	if c.taken {
+		return nil
+	}
+	return p.skipGroup()
+}
+
+// processElse processes #elif or #else in a group that is not skipped.
+// As the current group is taken, the rest of the if-section is skipped.
+func (p *preprocessor) processElse(hash *Token, dir *Token) error {
+	if len(p.conds) == 0 {
+		return errorAt(hash, "#%s without #if", dir.Val)
+	}
+	c := p.conds[len(p.conds)-1]
+	if c.elseSeen {
+		if err := p.report(conditionError(hash, c, "#%s after #else", dir.Val)); err != nil {
+			return err
+		}
+	}
+	if dir.Val == "else" {
+		c.elseSeen = true
+	}
+	if dir.Val == "else" {
+		if err := p.readEndOfDirective("else"); err != nil {
+			return err
+		}
+		return p.skipGroup()
+	}
+	// The expression of #elif is not evaluated.
+	if _, err := p.readLine(); err != nil {
+		return err
+	}
+	return p.skipGroup()
+}
+
+// processEndif processes #endif in a group that is not skipped.
+func (p *preprocessor) processEndif(hash *Token) error {
+	if len(p.conds) == 0 {
+		return errorAt(hash, "#endif without #if")
+	}
+	if err := p.readEndOfDirective("endif"); err != nil {
+		return err
+	}
+	p.conds = p.conds[:len(p.conds)-1]
+	return nil
+}
+
+// readEndOfDirective reads the rest of the line of #else or #endif. Extra
+// tokens are reported as a warning as GCC does.
+func (p *preprocessor) readEndOfDirective(name string) error {
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	if len(line) > 0 {
+		return p.report(warningAt(line[0], "extra tokens at end of #%s directive", name))
+	}
+	return nil
+}
+
+// skipGroup skips the tokens in the current group until another group in the
+// same if-section is taken by #elif or #else, or the if-section ends with
+// #endif.
+//
+// Tokens in skipped groups are not macro-expanded, and directives other than
+// conditional ones are ignored even if they are invalid. If-sections nested in
+// the skipped groups are still tracked to validate their structures.
+func (p *preprocessor) skipGroup() error {
+	for {
+		wasLineHead := p.src.AtLineHead()
+		t := p.src.skipPPToken()
+		if t.Type == EOF {
+			p.reportUnterminated()
+			return nil
+		}
+		if !wasLineHead || t.Type != '#' {
+			continue
+		}
+		hash := t
+
+		t = p.src.skipPPToken()
+		if t.Type != Identifier {
+			continue
+		}
+
+		switch t.Val {
+		case "if", "ifdef", "ifndef":
+			p.conds = append(p.conds, &condition{
+				directive: t.Val,
+				pos:       hash.Pos,
+				skipped:   true,
+			})
+		case "elif":
+			c := p.conds[len(p.conds)-1]
+			if c.elseSeen {
+				if err := p.report(conditionError(hash, c, "#elif after #else")); err != nil {
+					return err
+				}
+				continue
+			}
+			if c.skipped || c.taken {
+				continue
+			}
+			line, err := p.readLine()
+			if err != nil {
+				return err
+			}
+			v, err := p.evalCondition(t, line)
+			if err != nil {
+				return err
+			}
+			if v {
+				c.taken = true
+				return nil
+			}
+		case "else":
+			c := p.conds[len(p.conds)-1]
+			if c.elseSeen {
+				if err := p.report(conditionError(hash, c, "#else after #else")); err != nil {
+					return err
+				}
+			}
+			c.elseSeen = true
+			if c.skipped || c.taken {
+				continue
+			}
+			if err := p.readEndOfDirective("else"); err != nil {
+				return err
+			}
+			c.taken = true
+			return nil
+		case "endif":
+			c := p.conds[len(p.conds)-1]
+			p.conds = p.conds[:len(p.conds)-1]
+			if c.skipped {
+				continue
+			}
+			return p.readEndOfDirective("endif")
+		}
+	}
+}
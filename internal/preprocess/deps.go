@@ -0,0 +1,186 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+)
+
+// Dependency represents a header file that a translation unit depends on.
+type Dependency struct {
+	// Path is the resolved path of the header file.
+	Path string `json:"path"`
+
+	// System indicates whether the header file is found in a system
+	// directory.
+	System bool `json:"system"`
+}
+
+// Dependencies records the files opened during preprocessing, like cc -M.
+type Dependencies struct {
+	main    string
+	headers []Dependency
+	indices map[string]int
+}
+
+// reset starts recording the dependencies of the main file.
+func (d *Dependencies) reset(main string) {
+	d.main = main
+	d.headers = nil
+	d.indices = map[string]int{}
+}
+
+// add records the header file of path. A file is recorded only once even if
+// it is included repeatedly.
+func (d *Dependencies) add(path string, system bool) {
+	if _, ok := d.indices[path]; ok {
+		return
+	}
+	d.indices[path] = len(d.headers)
+	d.headers = append(d.headers, Dependency{
+		Path:   path,
+		System: system,
+	})
+}
+
+// Main returns the path of the main file.
+func (d *Dependencies) Main() string {
+	return d.main
+}
+
+// Headers returns the header files in the order they are first included.
+// Files included by #include whose contents are skipped by #pragma once or
+// include guards are also included.
+func (d *Dependencies) Headers() []Dependency {
+	return append([]Dependency{}, d.headers...)
+}
+
+// MakeOptions represents options for Dependencies.WriteMakefile.
+type MakeOptions struct {
+	// Targets are the targets of the rule, like -MT. If Targets is empty, the
+	// object file name of the main file like "main.o" is used.
+	Targets []string
+
+	// NoSystemHeaders indicates whether the system headers are omitted, like
+	// -MM.
+	NoSystemHeaders bool
+
+	// PhonyTargets indicates whether a phony target is added for each header,
+	// like -MP. This avoids errors by make when a header is removed.
+	PhonyTargets bool
+}
+
+// maxMakefileLineLength is the length of a line in a Makefile rule to be
+// continued with a backslash.
+const maxMakefileLineLength = 75
+
+// WriteMakefile writes the dependencies as a Makefile rule, like cc -M.
+// opts can be nil.
+func (d *Dependencies) WriteMakefile(w io.Writer, opts *MakeOptions) error {
+	if opts == nil {
+		opts = &MakeOptions{}
+	}
+	targets := opts.Targets
+	if len(targets) == 0 {
+		base := path.Base(d.main)
+		targets = []string{strings.TrimSuffix(base, path.Ext(base)) + ".o"}
+	}
+	headers := []string{}
+	for _, h := range d.headers {
+		if h.System && opts.NoSystemHeaders {
+			continue
+		}
+		headers = append(headers, h.Path)
+	}
+
+	var b strings.Builder
+	line := 0
+	writeWord := func(s string) {
+		if line > 0 && line+1+len(s) > maxMakefileLineLength {
+			b.WriteString(" \\\n ")
+			line = 1
+		}
+		if line > 0 {
+			b.WriteString(" ")
+			line++
+		}
+		b.WriteString(s)
+		line += len(s)
+	}
+	for i, t := range targets {
+		s := escapeMakefile(t)
+		if i == len(targets)-1 {
+			s += ":"
+		}
+		writeWord(s)
+	}
+	writeWord(escapeMakefile(d.main))
+	for _, h := range headers {
+		writeWord(escapeMakefile(h))
+	}
+	b.WriteString("\n")
+
+	if opts.PhonyTargets {
+		for _, h := range headers {
+			b.WriteString("\n" + escapeMakefile(h) + ":\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeMakefile escapes a file name in a Makefile rule as GCC does.
+func escapeMakefile(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case ' ', '\t':
+			// The preceding backslashes must also be escaped.
+			for j := i - 1; j >= 0 && name[j] == '\\'; j-- {
+				b.WriteByte('\\')
+			}
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '$':
+			b.WriteString("$$")
+		case '#':
+			b.WriteString("\\#")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// WriteJSON writes the dependencies in JSON in the form of
+//
+//	{"main": "main.c", "headers": [{"path": "foo.h", "system": false}]}
+func (d *Dependencies) WriteJSON(w io.Writer) error {
+	headers := d.headers
+	if headers == nil {
+		headers = []Dependency{}
+	}
+	return json.NewEncoder(w).Encode(struct {
+		Main    string       `json:"main"`
+		Headers []Dependency `json:"headers"`
+	}{
+		Main:    d.main,
+		Headers: headers,
+	})
+}
@@ -0,0 +1,194 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// embedParams represents the parameters of #embed.
+type embedParams struct {
+	// limit is the maximum number of bytes to embed, or -1 if not specified.
+	limit int64
+
+	// prefix, suffix and ifEmpty are the tokens of the parameters, or nil if
+	// not specified.
+	prefix  []*Token
+	suffix  []*Token
+	ifEmpty []*Token
+}
+
+// parseEmbedParams parses the embed parameter sequence of #embed.
+//
+// "6.10.4 Binary resource inclusion" [C23]
+func (p *preprocessor) parseEmbedParams(tokens []*Token) (*embedParams, error) {
+	params := &embedParams{
+		limit: -1,
+	}
+	seen := map[string]bool{}
+	for len(tokens) > 0 {
+		t := tokens[0]
+		if t.Type != Identifier {
+			return nil, errorAt(t, "expected embed parameter but %s", t)
+		}
+		name := t.Val
+		tokens = tokens[1:]
+
+		// A prefixed parameter like gnu::foo is not supported.
+		if len(tokens) >= 3 && tokens[0].Type == ':' && tokens[1].Type == ':' && tokens[1].Adjacent && tokens[2].Type == Identifier {
+			return nil, errorAt(t, "unknown embed parameter '%s::%s'", name, tokens[2].Val)
+		}
+		// "the embed parameter ... __std_param__ ... is treated as if it was
+		// std_param" [C23]
+		if len(name) > 4 && strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__") {
+			name = name[2 : len(name)-2]
+		}
+		switch name {
+		case "limit", "prefix", "suffix", "if_empty":
+		default:
+			return nil, errorAt(t, "unknown embed parameter '%s'", t.Val)
+		}
+		// "Each standard parameter shall appear at most once in the embed
+		// parameter sequence." [C23]
+		if seen[name] {
+			return nil, errorAt(t, "duplicate embed parameter '%s'", name)
+		}
+		seen[name] = true
+
+		if len(tokens) == 0 || tokens[0].Type != '(' {
+			return nil, errorAt(t, "embed parameter '%s' requires a parenthesized argument", name)
+		}
+		level := 0
+		i := 1
+		for ; ; i++ {
+			if i >= len(tokens) {
+				return nil, errorAt(tokens[0], "unterminated argument of embed parameter '%s'", name)
+			}
+			if tokens[i].Type == '(' {
+				level++
+			}
+			if tokens[i].Type == ')' {
+				if level == 0 {
+					break
+				}
+				level--
+			}
+		}
+		arg := tokens[1:i]
+		tokens = tokens[i+1:]
+
+		switch name {
+		case "limit":
+			// "The constant expression is evaluated as in #if" [C23]
+			ts, err := p.expandLine(arg, true)
+			if err != nil {
+				return nil, err
+			}
+			if len(ts) == 0 {
+				return nil, errorAt(t, "embed parameter 'limit' requires an expression")
+			}
+			v, err := evalIntegerExpression(ts, p.report)
+			if err != nil {
+				return nil, err
+			}
+			if !v.unsigned && v.val < 0 {
+				return nil, errorAt(ts[0], "embed parameter 'limit' must not be negative")
+			}
+			params.limit = v.val
+		case "prefix":
+			params.prefix = append([]*Token{}, arg...)
+		case "suffix":
+			params.suffix = append([]*Token{}, arg...)
+		case "if_empty":
+			params.ifEmpty = append([]*Token{}, arg...)
+		}
+	}
+	return params, nil
+}
+
+// processEmbed processes #embed. The resource is expanded into the
+// comma-separated list of the integer constants of its bytes, which is
+// rescanned with the rest of the source.
+//
+// The resource is read from the file system where header names are resolved.
+// As a file given as pre-tokenized tokens has no content, it is regarded as
+// empty.
+//
+// "6.10.4 Binary resource inclusion" [C23]
+func (p *preprocessor) processEmbed(hash *Token, dir *Token) error {
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	// Like #include, the operands are macro-expanded unless the directive is
+	// in the header name form.
+	if len(line) > 0 && line[0].Type != HeaderName {
+		line, err = p.expandLine(line, false)
+		if err != nil {
+			return err
+		}
+	}
+	h, rest, err := headerName(dir, "#embed", line)
+	if err != nil {
+		return err
+	}
+	params, err := p.parseEmbedParams(rest)
+	if err != nil {
+		return err
+	}
+
+	path, dirIndex, ok := p.resolver.resolve(h.Val, h.Raw[0] == '"', p.path, false, p.dirIndex)
+	if !ok {
+		return fatalAt(h, "'%s' file not found", h.Val)
+	}
+	if p.deps != nil {
+		p.deps.add(path, p.resolver.isSystemDir(dirIndex))
+	}
+	data, err := fs.ReadFile(p.resolver.fsys, path)
+	if err != nil {
+		return fatalAt(h, "%s: %v", h.Val, err)
+	}
+	if params.limit >= 0 && int64(len(data)) > params.limit {
+		data = data[:params.limit]
+	}
+
+	// "If the resource is empty ..., the if_empty parameter's tokens replace
+	// the directive, and the prefix and suffix parameters have no effect."
+	// [C23]
+	if len(data) == 0 {
+		p.sub = append(params.ifEmpty, p.sub...)
+		return nil
+	}
+	ts := append([]*Token{}, params.prefix...)
+	for i, b := range data {
+		if i > 0 {
+			ts = append(ts, &Token{
+				Type:     ',',
+				Val:      ",",
+				Raw:      ",",
+				Adjacent: true,
+				Pos:      h.Pos,
+			})
+		}
+		n := newPPNumber(strconv.Itoa(int(b)))
+		n.Pos = h.Pos
+		ts = append(ts, n)
+	}
+	ts = append(ts, params.suffix...)
+	p.sub = append(ts, p.sub...)
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"strings"
+
+	"github.com/hajimehoshi/goc/internal/diag"
+)
+
+// tokenRange returns the source range of the token t.
+//
+// For a token produced by macro expansion, the range points to the macro
+// invocation.
+func tokenRange(t *Token) diag.Range {
+	r := diag.Range{
+		Start: t.Position(),
+	}
+	if t.ExpansionPos.IsValid() || !t.Pos.IsValid() {
+		return r
+	}
+	// The end is unknown when the token is split by line splices.
+	if t.Raw == "" || strings.ContainsAny(t.Raw, "\\\n") {
+		return r
+	}
+	r.End = t.Pos
+	r.End.Column += len(t.Raw)
+	r.End.Offset += len(t.Raw)
+	return r
+}
+
+// newDiagnostic returns a new diagnostic at the token t.
+func newDiagnostic(severity diag.Severity, t *Token, format string, args ...interface{}) *diag.Diagnostic {
+	var d *diag.Diagnostic
+	switch severity {
+	case diag.Error:
+		d = diag.Errorf(t.Position(), format, args...)
+	case diag.Warning:
+		d = diag.Warningf(t.Position(), format, args...)
+	case diag.Fatal:
+		d = diag.Fatalf(t.Position(), format, args...)
+	default:
+		panic("not reached")
+	}
+	d.Range = tokenRange(t)
+	// The backtrace has the innermost frame first.
+	for i := len(t.expansions) - 1; i >= 0; i-- {
+		d.Backtrace = append(d.Backtrace, t.expansions[i])
+	}
+	if len(t.expansions) == 0 && t.ExpansionPos.IsValid() && t.Pos.IsValid() {
+		// The token is spelled in an argument of the invocation.
+		d.Backtrace = append(d.Backtrace, diag.Frame{
+			Kind: diag.ExpandedFrom,
+			Pos:  t.Pos,
+		})
+	}
+	return d
+}
+
+// errorAt returns a new error diagnostic at the token t.
+func errorAt(t *Token, format string, args ...interface{}) *diag.Diagnostic {
+	return newDiagnostic(diag.Error, t, format, args...)
+}
+
+// warningAt returns a new warning diagnostic at the token t.
+func warningAt(t *Token, format string, args ...interface{}) *diag.Diagnostic {
+	return newDiagnostic(diag.Warning, t, format, args...)
+}
+
+// fatalAt returns a new fatal error diagnostic at the token t.
+// Preprocessing stops at a fatal error.
+func fatalAt(t *Token, format string, args ...interface{}) *diag.Diagnostic {
+	return newDiagnostic(diag.Fatal, t, format, args...)
+}
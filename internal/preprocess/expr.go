@@ -0,0 +1,433 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"strings"
+
+	"github.com/hajimehoshi/goc/internal/ctype"
+	"github.com/hajimehoshi/goc/internal/lex"
+)
+
+// "6.10.1 Conditional inclusion" [spec]
+//
+// "For the purposes of this token conversion and evaluation, all signed
+// integer types and all unsigned integer types act as if they have the same
+// representation as, respectively, the types intmax_t and uintmax_t" [spec]
+
+// exprValue represents a value of intmax_t or uintmax_t.
+type exprValue struct {
+	val      int64
+	unsigned bool
+}
+
+func (v exprValue) isZero() bool {
+	return v.val == 0
+}
+
+func boolValue(b bool) exprValue {
+	if b {
+		return exprValue{val: 1}
+	}
+	return exprValue{val: 0}
+}
+
+// "6.3.1.8 Usual arithmetic conversions" [spec]
+func convertUsual(lhs, rhs exprValue) (exprValue, exprValue) {
+	if lhs.unsigned || rhs.unsigned {
+		lhs.unsigned = true
+		rhs.unsigned = true
+	}
+	return lhs, rhs
+}
+
+func parseIntegerConstant(t *Token) (exprValue, error) {
+	if strings.ContainsAny(t.Val, ".") {
+		return exprValue{}, errorAt(t, "floating constant in preprocessor expression")
+	}
+	if !strings.HasPrefix(t.Val, "0x") && !strings.HasPrefix(t.Val, "0X") && strings.ContainsAny(t.Val, "eE") {
+		return exprValue{}, errorAt(t, "floating constant in preprocessor expression")
+	}
+
+	src := newSource([]byte(t.Val), "")
+	v, err := lex.ReadNumber(src)
+	if err == lex.ErrIntegerTooLarge {
+		return exprValue{}, errorAt(t, "integer constant is too large for its type")
+	}
+	if err != nil {
+		return exprValue{}, errorAt(t, "invalid integer constant in preprocessor expression: %s", t.Val)
+	}
+	if bs, _ := src.Peek(1); len(bs) > 0 && bs[0] != '\n' {
+		return exprValue{}, errorAt(t, "invalid integer constant in preprocessor expression: %s", t.Val)
+	}
+
+	switch v.Type {
+	case ctype.UInt, ctype.ULong, ctype.ULongLong:
+		return exprValue{val: v.Value, unsigned: true}, nil
+	}
+	// A constant too large for intmax_t is treated as uintmax_t.
+	return exprValue{val: v.Value, unsigned: v.Value < 0}, nil
+}
+
+// parseCharacterConstant returns the value of the character constant t.
+// A warning about the value is reported by report.
+func parseCharacterConstant(t *Token, report func(error) error) (exprValue, error) {
+	v, w := t.CharacterValue()
+	if w != nil {
+		if err := report(w); err != nil {
+			return exprValue{}, err
+		}
+	}
+	switch v.Type {
+	case ctype.UChar, ctype.UShort, ctype.UInt:
+		// GCC treats char8_t, char16_t and char32_t constants as unsigned.
+		return exprValue{val: v.Value, unsigned: true}, nil
+	}
+	return exprValue{val: v.Value}, nil
+}
+
+type exprEvaluator struct {
+	tokens []*Token
+	pos    int
+
+	// eof is the token returned at the end of the expression.
+	eof *Token
+
+	// report reports a recoverable error or a warning.
+	report func(error) error
+}
+
+func (e *exprEvaluator) peek() *Token {
+	if e.pos >= len(e.tokens) {
+		return e.eof
+	}
+	return e.tokens[e.pos]
+}
+
+func (e *exprEvaluator) next() *Token {
+	t := e.peek()
+	if e.pos < len(e.tokens) {
+		e.pos++
+	}
+	return t
+}
+
+// evalExpression evaluates the controlling expression of #if or #elif.
+// The tokens must already be macro-expanded and the defined operators must be
+// already replaced. dir is the directive name token used to report errors.
+// Warnings are reported by report.
+func evalExpression(dir *Token, tokens []*Token, report func(error) error) (bool, error) {
+	if len(tokens) == 0 {
+		return false, errorAt(dir, "#%s with no expression", dir.Val)
+	}
+	v, err := evalIntegerExpression(tokens, report)
+	if err != nil {
+		return false, err
+	}
+	return !v.isZero(), nil
+}
+
+// evalIntegerExpression evaluates the non-empty tokens as an integer constant
+// expression in the same way as #if.
+func evalIntegerExpression(tokens []*Token, report func(error) error) (exprValue, error) {
+	e := &exprEvaluator{
+		tokens: tokens,
+		report: report,
+		eof: &Token{
+			Type: EOF,
+			Pos:  tokens[len(tokens)-1].Position(),
+		},
+	}
+	v, err := e.conditional(true)
+	if err != nil {
+		return exprValue{}, err
+	}
+	if t := e.peek(); t.Type != EOF {
+		return exprValue{}, errorAt(t, "missing binary operator before token %s", t)
+	}
+	return v, nil
+}
+
+// The argument eval of the below functions indicates whether the expression
+// is actually evaluated. Errors like division by zero are not reported in
+// unevaluated operands of &&, || and ?:.
+
+func (e *exprEvaluator) conditional(eval bool) (exprValue, error) {
+	cond, err := e.logicalOr(eval)
+	if err != nil {
+		return exprValue{}, err
+	}
+	if e.peek().Type != '?' {
+		return cond, nil
+	}
+	e.next()
+
+	lhs, err := e.conditional(eval && !cond.isZero())
+	if err != nil {
+		return exprValue{}, err
+	}
+	if t := e.next(); t.Type != ':' {
+		return exprValue{}, errorAt(t, "expected ':' in preprocessor expression but %s", t)
+	}
+	rhs, err := e.conditional(eval && cond.isZero())
+	if err != nil {
+		return exprValue{}, err
+	}
+
+	lhs, rhs = convertUsual(lhs, rhs)
+	if !cond.isZero() {
+		return lhs, nil
+	}
+	return rhs, nil
+}
+
+func (e *exprEvaluator) logicalOr(eval bool) (exprValue, error) {
+	lhs, err := e.logicalAnd(eval)
+	if err != nil {
+		return exprValue{}, err
+	}
+	for e.peek().Type == OrOr {
+		e.next()
+		rhs, err := e.logicalAnd(eval && lhs.isZero())
+		if err != nil {
+			return exprValue{}, err
+		}
+		lhs = boolValue(!lhs.isZero() || !rhs.isZero())
+	}
+	return lhs, nil
+}
+
+func (e *exprEvaluator) logicalAnd(eval bool) (exprValue, error) {
+	lhs, err := e.binary(eval, 0)
+	if err != nil {
+		return exprValue{}, err
+	}
+	for e.peek().Type == AndAnd {
+		e.next()
+		rhs, err := e.binary(eval && !lhs.isZero(), 0)
+		if err != nil {
+			return exprValue{}, err
+		}
+		lhs = boolValue(!lhs.isZero() && !rhs.isZero())
+	}
+	return lhs, nil
+}
+
+// binaryOps is the list of binary operators ordered by their precedences from
+// the lowest to the highest.
+var binaryOps = [][]TokenType{
+	{'|'},
+	{'^'},
+	{'&'},
+	{Eq, Ne},
+	{'<', '>', Le, Ge},
+	{Shl, Shr},
+	{'+', '-'},
+	{'*', '/', '%'},
+}
+
+func (e *exprEvaluator) binary(eval bool, level int) (exprValue, error) {
+	if level >= len(binaryOps) {
+		return e.unary(eval)
+	}
+
+	lhs, err := e.binary(eval, level+1)
+	if err != nil {
+		return exprValue{}, err
+	}
+loop:
+	for {
+		op := e.peek().Type
+		found := false
+		for _, o := range binaryOps[level] {
+			if op == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			break loop
+		}
+		opt := e.next()
+
+		rhs, err := e.binary(eval, level+1)
+		if err != nil {
+			return exprValue{}, err
+		}
+		lhs, err = applyBinaryOp(opt, lhs, rhs, eval)
+		if err != nil {
+			return exprValue{}, err
+		}
+	}
+	return lhs, nil
+}
+
+func applyBinaryOp(opt *Token, lhs, rhs exprValue, eval bool) (exprValue, error) {
+	op := opt.Type
+	switch op {
+	case Shl, Shr:
+		// The type of the result is that of the promoted left operand.
+		n := rhs.val
+		if !rhs.unsigned && n < 0 {
+			n = -n
+			if op == Shl {
+				op = Shr
+			} else {
+				op = Shl
+			}
+		}
+		if op == Shl {
+			if uint64(n) >= 64 {
+				return exprValue{val: 0, unsigned: lhs.unsigned}, nil
+			}
+			return exprValue{val: lhs.val << uint64(n), unsigned: lhs.unsigned}, nil
+		}
+		if lhs.unsigned {
+			if uint64(n) >= 64 {
+				return exprValue{val: 0, unsigned: true}, nil
+			}
+			return exprValue{val: int64(uint64(lhs.val) >> uint64(n)), unsigned: true}, nil
+		}
+		if uint64(n) >= 64 {
+			n = 63
+		}
+		return exprValue{val: lhs.val >> uint64(n)}, nil
+	}
+
+	lhs, rhs = convertUsual(lhs, rhs)
+	u := lhs.unsigned
+
+	switch op {
+	case '*':
+		return exprValue{val: lhs.val * rhs.val, unsigned: u}, nil
+	case '/', '%':
+		if rhs.val == 0 {
+			if !eval {
+				return exprValue{val: 0, unsigned: u}, nil
+			}
+			return exprValue{}, errorAt(opt, "division by zero in preprocessor expression")
+		}
+		if u {
+			if op == '/' {
+				return exprValue{val: int64(uint64(lhs.val) / uint64(rhs.val)), unsigned: true}, nil
+			}
+			return exprValue{val: int64(uint64(lhs.val) % uint64(rhs.val)), unsigned: true}, nil
+		}
+		// Avoid the overflow of INTMAX_MIN / -1.
+		if rhs.val == -1 {
+			if op == '/' {
+				return exprValue{val: -lhs.val}, nil
+			}
+			return exprValue{val: 0}, nil
+		}
+		if op == '/' {
+			return exprValue{val: lhs.val / rhs.val}, nil
+		}
+		return exprValue{val: lhs.val % rhs.val}, nil
+	case '+':
+		return exprValue{val: lhs.val + rhs.val, unsigned: u}, nil
+	case '-':
+		return exprValue{val: lhs.val - rhs.val, unsigned: u}, nil
+	case '<':
+		if u {
+			return boolValue(uint64(lhs.val) < uint64(rhs.val)), nil
+		}
+		return boolValue(lhs.val < rhs.val), nil
+	case '>':
+		if u {
+			return boolValue(uint64(lhs.val) > uint64(rhs.val)), nil
+		}
+		return boolValue(lhs.val > rhs.val), nil
+	case Le:
+		if u {
+			return boolValue(uint64(lhs.val) <= uint64(rhs.val)), nil
+		}
+		return boolValue(lhs.val <= rhs.val), nil
+	case Ge:
+		if u {
+			return boolValue(uint64(lhs.val) >= uint64(rhs.val)), nil
+		}
+		return boolValue(lhs.val >= rhs.val), nil
+	case Eq:
+		return boolValue(lhs.val == rhs.val), nil
+	case Ne:
+		return boolValue(lhs.val != rhs.val), nil
+	case '&':
+		return exprValue{val: lhs.val & rhs.val, unsigned: u}, nil
+	case '^':
+		return exprValue{val: lhs.val ^ rhs.val, unsigned: u}, nil
+	case '|':
+		return exprValue{val: lhs.val | rhs.val, unsigned: u}, nil
+	}
+	panic("not reached")
+}
+
+func (e *exprEvaluator) unary(eval bool) (exprValue, error) {
+	switch e.peek().Type {
+	case '+':
+		e.next()
+		return e.unary(eval)
+	case '-':
+		e.next()
+		v, err := e.unary(eval)
+		if err != nil {
+			return exprValue{}, err
+		}
+		return exprValue{val: -v.val, unsigned: v.unsigned}, nil
+	case '~':
+		e.next()
+		v, err := e.unary(eval)
+		if err != nil {
+			return exprValue{}, err
+		}
+		return exprValue{val: ^v.val, unsigned: v.unsigned}, nil
+	case '!':
+		e.next()
+		v, err := e.unary(eval)
+		if err != nil {
+			return exprValue{}, err
+		}
+		return boolValue(v.isZero()), nil
+	}
+	return e.primary(eval)
+}
+
+func (e *exprEvaluator) primary(eval bool) (exprValue, error) {
+	t := e.next()
+	switch t.Type {
+	case '(':
+		v, err := e.conditional(eval)
+		if err != nil {
+			return exprValue{}, err
+		}
+		if t := e.next(); t.Type != ')' {
+			return exprValue{}, errorAt(t, "missing ')' in preprocessor expression")
+		}
+		return v, nil
+	case PPNumber:
+		return parseIntegerConstant(t)
+	case CharacterConstant:
+		return parseCharacterConstant(t, e.report)
+	case Identifier:
+		// "After all replacements due to macro expansion and the defined unary
+		// operator have been performed, all remaining identifiers (including
+		// those lexically identical to keywords) are replaced with the pp-number
+		// 0" [spec]
+		return exprValue{val: 0}, nil
+	case EOF:
+		return exprValue{}, errorAt(t, "unexpected end of preprocessor expression")
+	}
+	return exprValue{}, errorAt(t, "token %s is not valid in preprocessor expressions", t)
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"io/fs"
+
+	"github.com/hajimehoshi/goc/internal/diag"
+)
+
+// file represents a source file.
+type file struct {
+	tokens []*Token
+
+	// guard is the name of the include guard macro, or empty if the file does
+	// not have an include guard.
+	guard string
+
+	// once indicates whether #pragma once has been processed in the file.
+	once bool
+}
+
+func newFile(tokens []*Token) *file {
+	return &file{
+		tokens: tokens,
+		guard:  detectIncludeGuard(tokens),
+	}
+}
+
+// fileCache reads files from a file system on demand, and caches them by their
+// resolved paths.
+type fileCache struct {
+	fsys  fs.FS
+	files map[string]*file
+
+	// tokenizeOptions is the options to tokenize files.
+	tokenizeOptions TokenizeOptions
+}
+
+func newFileCache(fsys fs.FS, tokens map[string][]*Token) *fileCache {
+	c := &fileCache{
+		fsys:  fsys,
+		files: map[string]*file{},
+	}
+	for path, ts := range tokens {
+		c.files[path] = newFile(ts)
+	}
+	return c
+}
+
+// cached returns the file at path if it is already loaded, or nil otherwise.
+func (c *fileCache) cached(path string) *file {
+	return c.files[path]
+}
+
+// load returns the file at path.
+//
+// If the file is tokenized with recoverable errors or warnings, load returns
+// the file with an error of diag.List. The errors are returned only at the
+// first time.
+func (c *fileCache) load(path string) (*file, error) {
+	if f, ok := c.files[path]; ok {
+		return f, nil
+	}
+	b, err := fs.ReadFile(c.fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	diags := &diag.Collector{}
+	ts := tokenize(b, path, &c.tokenizeOptions, diags)
+	err = nil
+	if ds := diags.Diagnostics(); len(ds) > 0 {
+		err = diag.List(ds)
+	}
+	if ts == nil {
+		return nil, err
+	}
+	f := newFile(ts)
+	c.files[path] = f
+	return f, err
+}
+
+// detectIncludeGuard returns the name of the include guard macro if the whole
+// file is enclosed by the if-section in the form of
+//
+//	#ifndef NAME
+//	...
+//	#endif
+//
+// or '#if !defined NAME' or '#if !defined(NAME)' instead of '#ifndef NAME'.
+// Otherwise, detectIncludeGuard returns an empty string.
+//
+// Such file can be skipped when it is included again while NAME is defined.
+func detectIncludeGuard(tokens []*Token) string {
+	// lines splits the tokens into lines without new-line tokens.
+	lines := [][]*Token{}
+	line := []*Token{}
+	for _, t := range tokens {
+		if t.Type == Comment {
+			continue
+		}
+		if t.Type == '\n' {
+			if len(line) > 0 {
+				lines = append(lines, line)
+			}
+			line = []*Token{}
+			continue
+		}
+		line = append(line, t)
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	if len(lines) < 2 {
+		return ""
+	}
+
+	directive := func(line []*Token) string {
+		if len(line) < 2 || line[0].Type != '#' || line[1].Type != Identifier {
+			return ""
+		}
+		return line[1].Val
+	}
+
+	var name string
+	switch first := lines[0]; directive(first) {
+	case "ifndef":
+		if len(first) != 3 || first[2].Type != Identifier {
+			return ""
+		}
+		name = first[2].Val
+	case "if":
+		ts := first[2:]
+		if len(ts) < 3 || ts[0].Type != '!' || ts[1].Type != Identifier || ts[1].Val != "defined" {
+			return ""
+		}
+		ts = ts[2:]
+		if len(ts) == 3 && ts[0].Type == '(' && ts[1].Type == Identifier && ts[2].Type == ')' {
+			name = ts[1].Val
+			break
+		}
+		if len(ts) == 1 && ts[0].Type == Identifier {
+			name = ts[0].Val
+			break
+		}
+		return ""
+	default:
+		return ""
+	}
+
+	// The #endif matching the first line must be the last line.
+	depth := 0
+	for i, line := range lines {
+		switch directive(line) {
+		case "if", "ifdef", "ifndef":
+			depth++
+		case "elif", "else":
+			if depth == 1 {
+				return ""
+			}
+		case "endif":
+			depth--
+			if depth == 0 {
+				if i != len(lines)-1 {
+					return ""
+				}
+				return name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,284 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/hajimehoshi/goc/internal/ctype"
+	"github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/lex"
+)
+
+// EncodingPrefix represents the encoding prefix of a character constant or a
+// string literal.
+//
+// "6.4.5 String literals" [spec]
+type EncodingPrefix int
+
+const (
+	// NoPrefix represents a character constant or a string literal without an
+	// encoding prefix. The execution character set is UTF-8.
+	NoPrefix EncodingPrefix = iota
+
+	// PrefixUTF8 represents u8. The encoding is UTF-8.
+	PrefixUTF8
+
+	// PrefixUTF16 represents u. The encoding is UTF-16 for char16_t.
+	PrefixUTF16
+
+	// PrefixUTF32 represents U. The encoding is UTF-32 for char32_t.
+	PrefixUTF32
+
+	// PrefixWide represents L. The encoding is UTF-32 as wchar_t is a signed
+	// 32-bit integer.
+	PrefixWide
+)
+
+func (e EncodingPrefix) String() string {
+	switch e {
+	case NoPrefix:
+		return ""
+	case PrefixUTF8:
+		return "u8"
+	case PrefixUTF16:
+		return "u"
+	case PrefixUTF32:
+		return "U"
+	case PrefixWide:
+		return "L"
+	}
+	panic("not reached")
+}
+
+// UnitSize returns the size of a code unit of the encoding in bytes.
+func (e EncodingPrefix) UnitSize() int {
+	switch e {
+	case NoPrefix, PrefixUTF8:
+		return 1
+	case PrefixUTF16:
+		return 2
+	case PrefixUTF32, PrefixWide:
+		return 4
+	}
+	panic("not reached")
+}
+
+// literalPrefix returns the encoding prefix if bs begins with an encoding
+// prefix followed by a quote.
+func literalPrefix(bs []byte) (EncodingPrefix, bool) {
+	for _, e := range []EncodingPrefix{PrefixUTF8, PrefixUTF16, PrefixUTF32, PrefixWide} {
+		s := e.String()
+		if len(bs) <= len(s) || string(bs[:len(s)]) != s {
+			continue
+		}
+		if q := bs[len(s)]; q == '"' || q == '\'' {
+			return e, true
+		}
+	}
+	return NoPrefix, false
+}
+
+// encode returns the code units of the characters in the encoding.
+//
+// "6.4.5 String literals" [spec]
+func (e EncodingPrefix) encode(chars []lex.Char) ([]uint32, error) {
+	max := uint32(0xffffffff) >> (32 - 8*uint(e.UnitSize()))
+	units := []uint32{}
+	for _, c := range chars {
+		if c.CodeUnit {
+			if c.Value > max {
+				return nil, fmt.Errorf("escape sequence out of range")
+			}
+			units = append(units, c.Value)
+			continue
+		}
+		switch e.UnitSize() {
+		case 1:
+			var buf [utf8.UTFMax]byte
+			n := utf8.EncodeRune(buf[:], rune(c.Value))
+			for _, b := range buf[:n] {
+				units = append(units, uint32(b))
+			}
+		case 2:
+			for _, u := range utf16.Encode([]rune{rune(c.Value)}) {
+				units = append(units, uint32(u))
+			}
+		case 4:
+			units = append(units, c.Value)
+		}
+	}
+	return units, nil
+}
+
+// decode returns the code units in the encoding as a string. For UTF-8, the
+// code units are the bytes of the string as they are. For the other
+// encodings, the code units are converted to UTF-8, and an invalid code unit
+// is converted to U+FFFD.
+func (e EncodingPrefix) decode(units []uint32) string {
+	switch e.UnitSize() {
+	case 1:
+		bs := make([]byte, len(units))
+		for i, u := range units {
+			bs[i] = byte(u)
+		}
+		return string(bs)
+	case 2:
+		us := make([]uint16, len(units))
+		for i, u := range units {
+			us[i] = uint16(u)
+		}
+		return string(utf16.Decode(us))
+	case 4:
+		rs := make([]rune, len(units))
+		for i, u := range units {
+			rs[i] = rune(u)
+			if !utf8.ValidRune(rs[i]) {
+				rs[i] = utf8.RuneError
+			}
+		}
+		return string(rs)
+	}
+	panic("not reached")
+}
+
+// byteUnits returns the bytes of s as code units of UTF-8.
+func byteUnits(s string) []uint32 {
+	units := make([]uint32, len(s))
+	for i := 0; i < len(s); i++ {
+		units[i] = uint32(s[i])
+	}
+	return units
+}
+
+// readLiteral reads a character constant or a string literal with the encoding
+// prefix.
+func readLiteral(src *source, prefix EncodingPrefix) (*Token, error) {
+	buf := newBufSource(src)
+	mustDiscard(buf, len(prefix.String()))
+	bs, err := buf.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := StringLiteral
+	var chars []lex.Char
+	if bs[0] == '"' {
+		chars, err = lex.ReadStringChars(buf)
+	} else {
+		typ = CharacterConstant
+		chars, err = lex.ReadCharConstantChars(buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	units, err := prefix.encode(chars)
+	if err != nil {
+		return nil, err
+	}
+	// "A UTF-8, UTF-16, or UTF-32 character constant shall not contain more
+	// than one character. The value shall be representable with a single
+	// UTF-8, UTF-16, or UTF-32 code unit." [C23]
+	if typ == CharacterConstant && prefix != NoPrefix && prefix != PrefixWide {
+		if len(chars) > 1 {
+			return nil, fmt.Errorf("character constant too long for its type")
+		}
+		if len(units) > 1 {
+			return nil, fmt.Errorf("character too large for enclosing character literal type")
+		}
+	}
+	return &Token{
+		Type:   typ,
+		Val:    prefix.decode(units),
+		Raw:    buf.Buf(),
+		Prefix: prefix,
+		Units:  units,
+	}, nil
+}
+
+// CharacterValue returns the value of the character constant t with its
+// type, and a warning about the value if any.
+//
+// The type of a character constant without an encoding prefix is int. The
+// types of the ones with L, u, U and u8 are wchar_t, char16_t, char32_t and
+// char8_t, which are represented by int, unsigned short, unsigned int and
+// unsigned char.
+//
+// "The value of an integer character constant containing more than one
+// character (e.g., 'ab'), or containing a character or escape sequence that
+// does not map to a single-byte execution character, is
+// implementation-defined." [spec]
+// Like GCC, the code units of such character constant are packed into an int
+// from the first one, and only the last four are kept. A wide character
+// constant containing more than one character has the value of the last one.
+func (t *Token) CharacterValue() (ctype.IntegerValue, *diag.Diagnostic) {
+	if len(t.Units) == 0 {
+		return ctype.IntegerValue{Type: ctype.Int}, nil
+	}
+	last := t.Units[len(t.Units)-1]
+	switch t.Prefix {
+	case NoPrefix:
+		if len(t.Units) == 1 {
+			// "If an integer character constant contains a single character
+			// or escape sequence, its value is the one that results when an
+			// object with type char whose value is that of the single
+			// character or escape sequence is converted to type int." [spec]
+			// char is signed.
+			return ctype.IntegerValue{Type: ctype.Int, Value: int64(int8(last))}, nil
+		}
+		var w *diag.Diagnostic
+		units := t.Units
+		if len(units) > 4 {
+			w = warningAt(t, "character constant too long for its type")
+			units = units[len(units)-4:]
+		} else {
+			w = warningAt(t, "multi-character character constant")
+		}
+		v := uint32(0)
+		for _, u := range units {
+			v = v<<8 | u
+		}
+		return ctype.IntegerValue{Type: ctype.Int, Value: int64(int32(v))}, w
+	case PrefixWide:
+		var w *diag.Diagnostic
+		if len(t.Units) > 1 {
+			w = warningAt(t, "character constant too long for its type")
+		}
+		// wchar_t is signed.
+		return ctype.IntegerValue{Type: ctype.Int, Value: int64(int32(last))}, w
+	case PrefixUTF8:
+		return ctype.IntegerValue{Type: ctype.UChar, Value: int64(last)}, nil
+	case PrefixUTF16:
+		return ctype.IntegerValue{Type: ctype.UShort, Value: int64(last)}, nil
+	case PrefixUTF32:
+		return ctype.IntegerValue{Type: ctype.UInt, Value: int64(last)}, nil
+	}
+	panic("not reached")
+}
+
+// reencodeString returns the code units of the string literal t in the
+// encoding of prefix.
+func reencodeString(t *Token, prefix EncodingPrefix) ([]uint32, error) {
+	if t.Prefix == prefix {
+		return t.Units, nil
+	}
+	chars, err := lex.ReadStringChars(newSource([]byte(t.Raw[len(t.Prefix.String()):]), ""))
+	if err != nil {
+		return nil, err
+	}
+	return prefix.encode(chars)
+}
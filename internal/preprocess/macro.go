@@ -15,126 +15,584 @@
 package preprocess
 
 import (
-	"fmt"
 	"strings"
 
-	"github.com/hajimehoshi/goc/internal/lex"
+	"github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/srcpos"
 )
 
 type macro struct {
 	name      string
 	tokens    []*Token
 	paramsLen int
+
+	// pos is the position of the macro name in the definition.
+	pos srcpos.Position
+
+	// params is the parameter names, or nil for an object-like macro.
+	params []string
+
+	// body is the replacement list as written in the definition.
+	body []*Token
+
+	// variadic indicates whether the macro takes variable arguments. If true,
+	// the last parameter represents __VA_ARGS__.
+	variadic bool
+
+	// vaOpts is the list of the replacement lists of __VA_OPT__.
+	vaOpts [][]*Token
+
+	// builtin returns the replacement of a dynamic predefined macro like
+	// __LINE__. builtin is nil for other macros.
+	builtin func(name *Token) *Token
 }
 
-func (m *macro) apply(src ppTokenReadPeeker, expandedFrom map[string]struct{}) ([]*Token, error) {
-	// Apply object-like macro.
-	if m.paramsLen == -1 {
-		for _, t := range m.tokens {
-			if t.ExpandedFrom == nil {
-				t.ExpandedFrom = map[string]struct{}{}
+// newMacro creates a macro from its definition. name is the macro name token.
+// params is nil for an object-like macro.
+func newMacro(name *Token, params []string, variadic bool, tokens []*Token) (macro, error) {
+	m := macro{
+		name:      name.Val,
+		paramsLen: -1,
+		pos:       name.Pos,
+		params:    params,
+		body:      tokens,
+		variadic:  variadic,
+	}
+	if params != nil {
+		m.paramsLen = len(params)
+	}
+	ts, err := m.replaceParams(tokens, params)
+	if err != nil {
+		return macro{}, err
+	}
+	m.tokens = ts
+	return m, nil
+}
+
+// identical reports whether the definitions of m and m2 are identical.
+//
+// "Two replacement lists are identical if and only if the preprocessing tokens
+// in both have the same number, ordering, spelling, and white-space
+// separation, where all white-space separations are considered identical."
+// [spec]
+func (m *macro) identical(m2 *macro) bool {
+	if m.builtin != nil || m2.builtin != nil {
+		return false
+	}
+	if m.paramsLen != m2.paramsLen || m.variadic != m2.variadic {
+		return false
+	}
+	// "... a function-like macro ... unless the second definition ... has the
+	// same number and spelling of parameters" [spec]
+	for i := range m.params {
+		if m.params[i] != m2.params[i] {
+			return false
+		}
+	}
+	if len(m.body) != len(m2.body) {
+		return false
+	}
+	for i := range m.body {
+		t, t2 := m.body[i], m2.body[i]
+		if t.Type != t2.Type || t.Raw != t2.Raw {
+			return false
+		}
+		if i > 0 && t.Adjacent != t2.Adjacent {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceParams replaces parameter identifier-like tokens with Param tokens,
+// and __VA_OPT__ with VaOpt tokens.
+func (m *macro) replaceParams(tokens []*Token, params []string) ([]*Token, error) {
+	// "A ## preprocessing token shall not occur at the beginning or at the end
+	// of a replacement list for either form of macro definition." [spec]
+	if len(tokens) > 0 && tokens[0].Type == HashHash {
+		return nil, errorAt(tokens[0], "'##' cannot appear at either end of a macro expansion")
+	}
+	if len(tokens) > 0 && tokens[len(tokens)-1].Type == HashHash {
+		return nil, errorAt(tokens[len(tokens)-1], "'##' cannot appear at either end of a macro expansion")
+	}
+
+	paramIndex := func(name string) int {
+		for i, p := range params {
+			if name == p {
+				return i
+			}
+		}
+		return -1
+	}
+
+	r := []*Token{}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+
+		// "The identifiers __VA_ARGS__ and __VA_OPT__ shall occur only in the
+		// replacement-list of a function-like macro that uses the ellipsis
+		// notation in the parameters." [spec]
+		if t.Type == Identifier && (t.Val == "__VA_ARGS__" || t.Val == "__VA_OPT__") && !m.variadic {
+			return nil, errorAt(t, "%s can only appear in the expansion of a variadic macro", t.Val)
+		}
+
+		if m.paramsLen == -1 {
+			r = append(r, t)
+			continue
+		}
+
+		hash := false
+		if t.Type == '#' {
+			i++
+			if i >= len(tokens) {
+				return nil, errorAt(t, "'#' is not followed by a macro parameter")
 			}
-			for n := range expandedFrom {
-				t.ExpandedFrom[n] = struct{}{}
+			if t := tokens[i]; t.Type != Identifier || (paramIndex(t.Val) == -1 && t.Val != "__VA_OPT__") {
+				return nil, errorAt(tokens[i-1], "'#' is not followed by a macro parameter")
 			}
-			t.ExpandedFrom[m.name] = struct{}{}
+			t = tokens[i]
+			hash = true
 		}
-		return m.tokens, nil
+
+		if t.Type != Identifier {
+			r = append(r, t)
+			continue
+		}
+
+		if t.Val == "__VA_OPT__" {
+			// "6.10.5.1 __VA_OPT__" [C23]
+			i++
+			if i >= len(tokens) || tokens[i].Type != '(' {
+				return nil, errorAt(t, "__VA_OPT__ must be followed by '('")
+			}
+			level := 0
+			start := i + 1
+			for i++; ; i++ {
+				if i >= len(tokens) {
+					return nil, errorAt(tokens[start-2], "unterminated __VA_OPT__")
+				}
+				t := tokens[i]
+				if t.Type == Identifier && t.Val == "__VA_OPT__" {
+					return nil, errorAt(t, "__VA_OPT__ may not appear in a __VA_OPT__ operand")
+				}
+				if t.Type == '(' {
+					level++
+				}
+				if t.Type == ')' {
+					if level == 0 {
+						break
+					}
+					level--
+				}
+			}
+			ts, err := m.replaceParams(tokens[start:i], params)
+			if err != nil {
+				return nil, err
+			}
+			m.vaOpts = append(m.vaOpts, ts)
+			r = append(r, &Token{
+				Type:       VaOpt,
+				Pos:        tokens[start-2].Pos,
+				ParamIndex: len(m.vaOpts) - 1,
+				ParamHash:  hash,
+			})
+			continue
+		}
+
+		idx := paramIndex(t.Val)
+		if idx == -1 {
+			r = append(r, t)
+			continue
+		}
+		pos := t.Pos
+		if hash {
+			pos = tokens[i-1].Pos
+		}
+		r = append(r, &Token{
+			Type:       Param,
+			Pos:        pos,
+			ParamIndex: idx,
+			ParamHash:  hash,
+		})
+	}
+	return r, nil
+}
+
+// hideSet represents a set of macro names.
+type hideSet map[string]struct{}
+
+// union returns a new hide set that has both the names in hs and the names
+// in hs2.
+func (hs hideSet) union(hs2 hideSet) hideSet {
+	r := hideSet{}
+	for n := range hs {
+		r[n] = struct{}{}
+	}
+	for n := range hs2 {
+		r[n] = struct{}{}
+	}
+	return r
+}
+
+// intersection returns a new hide set that has the names in both hs and hs2.
+func (hs hideSet) intersection(hs2 hideSet) hideSet {
+	r := hideSet{}
+	for n := range hs {
+		if _, ok := hs2[n]; ok {
+			r[n] = struct{}{}
+		}
+	}
+	return r
+}
+
+// apply applies the macro invoked by the given name token, and returns the
+// resulting tokens. The arguments of a function-like macro are read from p,
+// and they can extend beyond the tokens already produced by other macro
+// expansions.
+//
+// The expansion follows the hide set algorithm by Dave Prosser, which is the
+// basis of "6.10.3.4 Rescanning and further replacement" [spec]. Every
+// resulting token is a copy whose hide set is the hide set of the
+// invocation with the macro name added.
+func (m *macro) apply(p *preprocessor, name *Token) ([]*Token, error) {
+	hs := hideSet(name.ExpandedFrom).union(hideSet{m.name: {}})
+
+	if m.builtin != nil {
+		// A built-in macro has no replacement list to be noted.
+		t := m.builtin(name)
+		t.Pos = name.Pos
+		t.expansions = name.expansions
+		return []*Token{argToken(t, hs, name)}, nil
+	}
+
+	// Apply object-like macro.
+	if m.paramsLen == -1 {
+		return m.substitute(p, m.tokens, nil, nil, hs, name)
 	}
 
 	// Apply function-like macro.
 	// Parse arguments
+	src := &expansionReader{p: p}
 	if _, err := nextExpected(src, '('); err != nil {
 		return nil, err
 	}
 
 	args := [][]*Token{}
-	t, err := src.peekPPToken()
+	var rparen *Token
+args:
+	for {
+		arg := []*Token{}
+		// The variable arguments including the separating commas are
+		// treated as one argument.
+		va := m.variadic && len(args) == m.paramsLen-1
+		level := 0
+		for {
+			t, err := src.NextPPToken()
+			if err != nil {
+				return nil, err
+			}
+			if t.Type == EOF {
+				return nil, errorAt(name, "unterminated argument list invoking macro \"%s\"", m.name)
+			}
+			if t.Type == ')' && level == 0 {
+				args = append(args, arg)
+				rparen = t
+				break args
+			}
+			if t.Type == ',' && level == 0 && !va {
+				args = append(args, arg)
+				break
+			}
+			arg = append(arg, t)
+			if t.Type == '(' {
+				level++
+			}
+			if t.Type == ')' {
+				level--
+			}
+		}
+	}
+
+	// An empty argument list invokes a macro without parameters.
+	if m.paramsLen == 0 && len(args) == 1 && len(args[0]) == 0 {
+		args = args[:0]
+	}
+
+	// The variable arguments can be omitted.
+	if m.variadic && len(args) == m.paramsLen-1 {
+		args = append(args, []*Token{})
+	}
+
+	if len(args) != m.paramsLen {
+		if m.variadic {
+			return nil, errorAt(name, "macro \"%s\" requires at least %d arguments, but %d given", m.name, m.paramsLen-1, len(args))
+		}
+		return nil, errorAt(name, "macro \"%s\" requires %d arguments, but %d given", m.name, m.paramsLen, len(args))
+	}
+
+	// The hide set of a function-like macro invocation is the intersection of
+	// the hide sets of the name and the closing parenthesis, so that a macro
+	// name produced by the expansion can be invoked with the arguments after
+	// the expansion.
+	hs = hideSet(name.ExpandedFrom).intersection(rparen.ExpandedFrom).union(hideSet{m.name: {}})
+	return m.substitute(p, m.tokens, args, make([][]*Token, len(args)), hs, name)
+}
+
+// isFuncLike reports whether the macro is a function-like macro.
+func (m *macro) isFuncLike() bool {
+	return m.paramsLen != -1 && m.builtin == nil
+}
+
+// expandArg returns the i-th argument with all the macros contained therein
+// expanded. The result is cached in expandedArgs. The argument tokens are
+// regarded as a part of the invocation by the given name token, e.g.,
+// __LINE__ in the argument is the line of the invocation.
+//
+// "A parameter in the replacement list, unless preceded by a # or ##
+// preprocessing token or followed by a ## preprocessing token, is replaced by
+// the corresponding argument after all macros contained therein have been
+// expanded. Before being substituted, each argument's preprocessing tokens are
+// completely macro replaced as if they formed the rest of the preprocessing
+// file; no other preprocessing tokens are available." [spec]
+func expandArg(p *preprocessor, name *Token, args, expandedArgs [][]*Token, i int) ([]*Token, error) {
+	if expandedArgs[i] != nil {
+		return expandedArgs[i], nil
+	}
+	arg := make([]*Token, 0, len(args[i]))
+	for _, a := range args[i] {
+		a := *a
+		a.ExpansionPos = name.Position()
+		arg = append(arg, &a)
+	}
+	ts, err := p.expandLine(arg, false)
 	if err != nil {
 		return nil, err
 	}
-	if t.Type == ')' {
-		if _, err := nextExpected(src, ')'); err != nil {
-			panic("not reached")
+	expandedArgs[i] = ts
+	return ts, nil
+}
+
+// hasVarArgs reports whether the variable arguments consist of one or more
+// preprocessing tokens after macro expansion.
+func (m *macro) hasVarArgs(p *preprocessor, name *Token, args, expandedArgs [][]*Token) (bool, error) {
+	if !m.variadic {
+		return false, nil
+	}
+	ts, err := expandArg(p, name, args, expandedArgs, m.paramsLen-1)
+	if err != nil {
+		return false, err
+	}
+	return len(ts) > 0, nil
+}
+
+// substitute returns the given replacement list where the parameters are
+// replaced with the arguments and the ## operators are processed. Each
+// resulting token is marked with the hide set hs.
+func (m *macro) substitute(p *preprocessor, tokens []*Token, args, expandedArgs [][]*Token, hs hideSet, name *Token) ([]*Token, error) {
+	r, err := m.substituteWithPlacemarkers(p, tokens, args, expandedArgs, hs, name)
+	if err != nil {
+		return nil, err
+	}
+	// "After all replacements ... placemarker preprocessing tokens are
+	// removed." [spec]
+	return removePlacemarkers(r), nil
+}
+
+// substituteWithPlacemarkers is like substitute, but the placemarkers are not
+// removed.
+//
+// The result of __VA_OPT__ keeps its placemarkers until the enclosing
+// replacement list is processed, as __VA_OPT__ can be an operand of ##.
+func (m *macro) substituteWithPlacemarkers(p *preprocessor, tokens []*Token, args, expandedArgs [][]*Token, hs hideSet, name *Token) ([]*Token, error) {
+	r := []*Token{}
+	paste := false
+	for i, t := range tokens {
+		if t.Type == HashHash {
+			paste = true
+			continue
 		}
-	} else {
-	args:
-		for {
-			arg := []*Token{}
-			level := 0
-			for {
-				t, err := src.NextPPToken()
+
+		var ts []*Token
+		switch {
+		case t.Type == VaOpt:
+			ok, err := m.hasVarArgs(p, name, args, expandedArgs)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				ts, err = m.substituteWithPlacemarkers(p, m.vaOpts[t.ParamIndex], args, expandedArgs, hs, name)
 				if err != nil {
 					return nil, err
 				}
-				if t.Type == EOF {
-					return nil, fmt.Errorf("preprocess: unexpected EOF")
+			}
+			if t.ParamHash {
+				s, err := stringify(removePlacemarkers(ts))
+				if err != nil {
+					return nil, err
 				}
-				if t.Type == ')' && level == 0 {
-					args = append(args, arg)
-					break args
+				s.Pos = t.Pos
+				ts = []*Token{expandedToken(s, hs, name)}
+			}
+			if len(ts) == 0 {
+				ts = []*Token{
+					{
+						Type: Placemarker,
+					},
 				}
-				if t.Type == ',' && level == 0 {
-					args = append(args, arg)
-					break
+			}
+		case t.Type != Param:
+			ts = []*Token{expandedToken(t, hs, name)}
+		case t.ParamHash:
+			s, err := stringify(args[t.ParamIndex])
+			if err != nil {
+				return nil, err
+			}
+			s.Pos = t.Pos
+			ts = []*Token{expandedToken(s, hs, name)}
+		default:
+			// The operands of ## are not macro-expanded.
+			arg := args[t.ParamIndex]
+			if !paste && (i+1 >= len(tokens) || tokens[i+1].Type != HashHash) {
+				var err error
+				arg, err = expandArg(p, name, args, expandedArgs, t.ParamIndex)
+				if err != nil {
+					return nil, err
 				}
-				arg = append(arg, t)
-				if t.Type == '(' {
-					level++
+			}
+			ts = make([]*Token, 0, len(arg))
+			for _, a := range arg {
+				ts = append(ts, argToken(a, hs, name))
+			}
+
+			// As a GNU extension, ', ## __VA_ARGS__' removes the comma when the
+			// variable arguments are empty, and the ## does nothing otherwise.
+			if paste && m.variadic && t.ParamIndex == m.paramsLen-1 && tokens[i-2].Type == ',' {
+				paste = false
+				if len(ts) == 0 {
+					r = r[:len(r)-1]
 				}
-				if t.Type == ')' {
-					level--
+				break
+			}
+
+			// "If, in the replacement list of a function-like macro, a parameter
+			// is immediately preceded or followed by a ## preprocessing token,
+			// the parameter is replaced by the corresponding argument's
+			// preprocessing token sequence; however, if an argument consists of no
+			// preprocessing tokens, the parameter is replaced by a placemarker
+			// preprocessing token instead." [spec]
+			if len(ts) == 0 && (paste || (i+1 < len(tokens) && tokens[i+1].Type == HashHash)) {
+				ts = []*Token{
+					{
+						Type: Placemarker,
+					},
 				}
 			}
 		}
-	}
-
-	if len(args) != m.paramsLen {
-		return nil, fmt.Errorf("preprocess: expected %d args but %d", m.paramsLen, len(args))
-	}
 
-	r := []*Token{}
-	for _, t := range m.tokens {
-		if t.Type != Param {
-			if t.ExpandedFrom == nil {
-				t.ExpandedFrom = map[string]struct{}{}
+		if paste {
+			paste = false
+			// The definition ensures that ## is not at either end of the
+			// replacement list, and a placemarker is inserted for an empty
+			// argument. Then the both operands must exist.
+			t, err := pasteTokens(r[len(r)-1], ts[0])
+			if err != nil {
+				return nil, err
 			}
-			for n := range expandedFrom {
-				t.ExpandedFrom[n] = struct{}{}
+			if t.Type != Placemarker {
+				t = expandedToken(t, hs, name)
 			}
-			t.ExpandedFrom[m.name] = struct{}{}
-			r = append(r, t)
-			continue
+			r[len(r)-1] = t
+			ts = ts[1:]
 		}
-		if !t.ParamHash {
-			r = append(r, args[t.ParamIndex]...)
+		r = append(r, ts...)
+	}
+	return r, nil
+}
+
+// removePlacemarkers returns the tokens without the placemarkers.
+func removePlacemarkers(tokens []*Token) []*Token {
+	r := []*Token{}
+	for _, t := range tokens {
+		if t.Type == Placemarker {
 			continue
 		}
+		r = append(r, t)
+	}
+	return r
+}
 
-		// "6.10.3.2 The # operator" [spec]
-		lit := ""
-		for _, p := range args[t.ParamIndex] {
-			raw := p.Raw
-			if p.Type == StringLiteral {
-				raw = strings.Replace(strings.Replace(p.Raw, `\`, `\\`, -1), `"`, `\"`, -1)
-			}
-			if p.Adjacent || lit == "" {
-				lit += raw
-			} else {
-				lit += " " + raw
-			}
+// expandedToken returns a copy of the token in the replacement list of the
+// macro invoked by the given name token. The hide set of the copy is the union
+// of the token's and hs. The location of the token in the replacement list is
+// added to the macro-expansion backtrace of the invocation.
+//
+// The tokens in the macro definitions and the arguments are never modified,
+// as they can be shared by other expansions.
+func expandedToken(t *Token, hs hideSet, name *Token) *Token {
+	c := argToken(t, hs, name)
+	c.expansions = append(name.expansions[:len(name.expansions):len(name.expansions)], diag.Frame{
+		Kind: diag.ExpandedFrom,
+		Pos:  t.Pos,
+		Name: name.Val,
+	})
+	return c
+}
+
+// argToken is like expandedToken, but for a token of the arguments. The token
+// keeps its macro-expansion backtrace, which already includes the invocation's
+// as the arguments are read from the same tokens as the invocation.
+func argToken(t *Token, hs hideSet, name *Token) *Token {
+	c := *t
+	c.ExpandedFrom = hideSet(t.ExpandedFrom).union(hs)
+	c.ExpansionPos = name.Position()
+	return &c
+}
+
+// "6.10.3.2 The # operator" [spec]
+func stringify(tokens []*Token) (*Token, error) {
+	lit := ""
+	for _, p := range tokens {
+		raw := p.Raw
+		// "a \ character is inserted before each " and \ character of a
+		// character constant or string literal" [spec]
+		if p.Type == StringLiteral || p.Type == CharacterConstant {
+			raw = strings.Replace(strings.Replace(p.Raw, `\`, `\\`, -1), `"`, `\"`, -1)
 		}
-		raw := `"` + lit + `"`
-		// TODO: Give the correct filename?
-		val, err := lex.ReadString(newSource([]byte(raw), ""))
-		if err != nil {
-			return nil, err
+		if p.Adjacent || lit == "" {
+			lit += raw
+		} else {
+			lit += " " + raw
 		}
-		r = append(r, &Token{
-			Type: StringLiteral,
-			Val:  val,
-			Raw:  raw,
-		})
 	}
-	return r, nil
+	raw := `"` + lit + `"`
+	// TODO: Give the correct filename?
+	return readLiteral(newSource([]byte(raw), ""), NoPrefix)
+}
+
+// "6.10.3.3 The ## operator" [spec]
+func pasteTokens(lhs, rhs *Token) (*Token, error) {
+	if lhs.Type == Placemarker {
+		return rhs, nil
+	}
+	if rhs.Type == Placemarker {
+		return lhs, nil
+	}
+
+	// "If the result is not a valid preprocessing token, the behavior is
+	// undefined." [spec]
+	// The concatenated spelling is tokenized again, and it must form exactly one
+	// preprocessing token.
+	t := &tokenizer{
+		src: newSource([]byte(lhs.Raw+rhs.Raw), ""),
+	}
+	tk, err := t.NextPPToken()
+	if err != nil || tk.Type == '\n' {
+		return nil, errorAt(lhs, "pasting %s and %s does not give a valid preprocessing token", lhs, rhs)
+	}
+	if t, err := t.NextPPToken(); err != nil || t.Type != '\n' {
+		return nil, errorAt(lhs, "pasting %s and %s does not give a valid preprocessing token", lhs, rhs)
+	}
+	tk.Adjacent = lhs.Adjacent
+	tk.Pos = lhs.Pos
+	return tk, nil
 }
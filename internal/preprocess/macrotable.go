@@ -0,0 +1,132 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+// Macro represents a macro definition.
+type Macro struct {
+	// Name is the macro name.
+	Name string
+
+	// Pos is the position of the macro name in the definition. The file name
+	// of a predefined macro is "<built-in>".
+	Pos srcpos.Position
+
+	// Params is the parameter names of a function-like macro, or nil for an
+	// object-like macro. The ellipsis of a variadic macro is not included.
+	Params []string
+
+	// Variadic indicates whether the function-like macro takes variable
+	// arguments.
+	Variadic bool
+
+	// Replacement is the replacement list.
+	Replacement []*Token
+}
+
+// IsFunctionLike reports whether the macro is a function-like macro.
+func (m *Macro) IsFunctionLike() bool {
+	return m.Params != nil
+}
+
+// String returns the definition of the macro as a #define directive, like
+// gcc -dM.
+func (m *Macro) String() string {
+	var b strings.Builder
+	b.WriteString("#define ")
+	b.WriteString(m.Name)
+	if m.IsFunctionLike() {
+		ps := append([]string{}, m.Params...)
+		if m.Variadic {
+			ps = append(ps, "...")
+		}
+		b.WriteString("(" + strings.Join(ps, ",") + ")")
+	}
+	for i, t := range m.Replacement {
+		if i == 0 || !t.Adjacent {
+			b.WriteString(" ")
+		}
+		b.WriteString(t.Raw)
+	}
+	return b.String()
+}
+
+// MacroTable is a table of the macros defined at the end of preprocessing.
+//
+// The dynamic predefined macros like __LINE__ are not included, as their
+// replacements depend on where they are invoked.
+type MacroTable struct {
+	macros map[string]*Macro
+}
+
+// set replaces the content of the table with the given macros.
+func (t *MacroTable) set(macros map[string]macro) {
+	t.macros = map[string]*Macro{}
+	for name, m := range macros {
+		if m.builtin != nil {
+			continue
+		}
+		mc := &Macro{
+			Name:        name,
+			Pos:         m.pos,
+			Replacement: m.body,
+			Variadic:    m.variadic,
+		}
+		if m.params != nil {
+			mc.Params = m.params
+			if m.variadic {
+				mc.Params = m.params[:len(m.params)-1]
+			}
+		}
+		t.macros[name] = mc
+	}
+}
+
+// Lookup returns the macro of the given name.
+func (t *MacroTable) Lookup(name string) (*Macro, bool) {
+	m, ok := t.macros[name]
+	return m, ok
+}
+
+// Macros returns all the macros sorted by their names.
+func (t *MacroTable) Macros() []*Macro {
+	ms := make([]*Macro, 0, len(t.macros))
+	for _, m := range t.macros {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Name < ms[j].Name
+	})
+	return ms
+}
+
+// WriteDefines writes the #define directives of all the macros to w, like
+// gcc -dM -E.
+func (t *MacroTable) WriteDefines(w io.Writer) error {
+	for _, m := range t.Macros() {
+		if _, err := fmt.Fprintln(w, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
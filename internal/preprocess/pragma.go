@@ -0,0 +1,316 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"strings"
+
+	"github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+// Pragma represents a pragma given by #pragma or the _Pragma operator.
+type Pragma struct {
+	// Name is the name of the handler that handles the pragma, e.g.
+	// "GCC diagnostic".
+	Name string
+
+	// Tokens are the preprocessing tokens following the name. Tokens are not
+	// macro-expanded.
+	Tokens []*Token
+
+	// Pos is the position of the pragma.
+	Pos srcpos.Position
+
+	// state is the state of the pragmas in the translation unit.
+	state *pragmaState
+}
+
+// pragmaState is the state of the pragmas kept during a preprocessing run.
+// This is not kept in PragmaRegistry, as a registry can be used for multiple
+// runs.
+type pragmaState struct {
+	// diagnosticDepth is the depth of '#pragma GCC diagnostic push'.
+	diagnosticDepth int
+}
+
+// PragmaHandler handles a pragma.
+//
+// A returned *diag.Diagnostic is reported with its severity. Other errors are
+// reported as errors at the pragma.
+type PragmaHandler func(pragma *Pragma) error
+
+// PragmaRegistry is a set of pragma handlers.
+//
+// A handler is registered by a name consisting of identifiers separated by
+// spaces, like "once", "STDC FP_CONTRACT" or "GCC diagnostic". A pragma is
+// handled by the handler with the longest name matching its leading
+// identifiers. A handler registered by a namespace like "goc" handles all the
+// pragmas in the namespace that have no more specific handlers.
+type PragmaRegistry struct {
+	handlers map[string]PragmaHandler
+}
+
+// NewPragmaRegistry returns a new PragmaRegistry with the standard handlers:
+//
+//   - once
+//   - pack (recognized but has no effect on preprocessing)
+//   - STDC FP_CONTRACT, STDC FENV_ACCESS and STDC CX_LIMITED_RANGE
+//   - GCC diagnostic, GCC system_header, GCC warning and GCC error
+//
+// "once" is handled by the preprocessor and cannot be overridden.
+func NewPragmaRegistry() *PragmaRegistry {
+	r := &PragmaRegistry{
+		handlers: map[string]PragmaHandler{},
+	}
+	r.Register("pack", func(pragma *Pragma) error {
+		return nil
+	})
+
+	// "7.12.2 The FP_CONTRACT pragma" [spec] etc.
+	onOffSwitch := func(pragma *Pragma) error {
+		if len(pragma.Tokens) == 0 {
+			return diag.Warningf(pragma.Pos, "expected 'ON' or 'OFF' or 'DEFAULT' in pragma")
+		}
+		t := pragma.Tokens[0]
+		if t.Type != Identifier || (t.Val != "ON" && t.Val != "OFF" && t.Val != "DEFAULT") {
+			return warningAt(t, "expected 'ON' or 'OFF' or 'DEFAULT' in pragma")
+		}
+		return nil
+	}
+	r.Register("STDC FP_CONTRACT", onOffSwitch)
+	r.Register("STDC FENV_ACCESS", onOffSwitch)
+	r.Register("STDC CX_LIMITED_RANGE", onOffSwitch)
+	r.Register("STDC", func(pragma *Pragma) error {
+		return diag.Warningf(pragma.Pos, "unknown pragma in STDC namespace")
+	})
+
+	r.Register("GCC diagnostic", func(pragma *Pragma) error {
+		if len(pragma.Tokens) == 0 || pragma.Tokens[0].Type != Identifier {
+			return diag.Warningf(pragma.Pos, "pragma diagnostic expected 'error', 'warning', 'ignored', 'push' or 'pop'")
+		}
+		t := pragma.Tokens[0]
+		switch t.Val {
+		case "push":
+			pragma.state.diagnosticDepth++
+		case "pop":
+			if pragma.state.diagnosticDepth == 0 {
+				return warningAt(t, "pragma diagnostic pop could not pop, no matching push")
+			}
+			pragma.state.diagnosticDepth--
+		case "error", "warning", "ignored":
+			if len(pragma.Tokens) < 2 || pragma.Tokens[1].Type != StringLiteral {
+				return warningAt(t, "expected option name (e.g. \"-Wundef\")")
+			}
+		default:
+			return warningAt(t, "pragma diagnostic expected 'error', 'warning', 'ignored', 'push' or 'pop'")
+		}
+		return nil
+	})
+	r.Register("GCC system_header", func(pragma *Pragma) error {
+		return nil
+	})
+	message := func(pragma *Pragma) string {
+		var strs []string
+		for _, t := range pragma.Tokens {
+			if t.Type == StringLiteral {
+				strs = append(strs, t.Val)
+			}
+		}
+		return strings.Join(strs, "")
+	}
+	r.Register("GCC warning", func(pragma *Pragma) error {
+		return diag.Warningf(pragma.Pos, "%s", message(pragma))
+	})
+	r.Register("GCC error", func(pragma *Pragma) error {
+		return diag.Errorf(pragma.Pos, "%s", message(pragma))
+	})
+	return r
+}
+
+// Register registers the handler for the name. An existing handler for the
+// same name is replaced.
+func (r *PragmaRegistry) Register(name string, handler PragmaHandler) {
+	r.handlers[strings.Join(strings.Fields(name), " ")] = handler
+}
+
+// lookup returns the handler for the pragma tokens, the name of the handler and
+// the rest tokens. lookup returns nil if there is no handler.
+func (r *PragmaRegistry) lookup(tokens []*Token) (PragmaHandler, string, []*Token) {
+	var found PragmaHandler
+	var foundName string
+	var rest []*Token
+	name := ""
+	for i, t := range tokens {
+		if t.Type != Identifier {
+			break
+		}
+		if name != "" {
+			name += " "
+		}
+		name += t.Val
+		if h, ok := r.handlers[name]; ok {
+			found = h
+			foundName = name
+			rest = tokens[i+1:]
+		}
+	}
+	return found, foundName, rest
+}
+
+// handlePragma handles the tokens of a pragma. at is the token to report
+// errors.
+//
+// "6.10.6 Pragma directive" [spec]
+// "Any such pragma that is not recognized by the implementation is ignored."
+//
+// If pragmas are kept, the pragma is also passed through as a PragmaDirective
+// token for the compiler, as cc -E does. Only #pragma once, which is
+// processed by the preprocessor, is not passed through.
+func (p *preprocessor) handlePragma(at *Token, tokens []*Token) error {
+	if len(tokens) > 0 && tokens[0].Type == Identifier && tokens[0].Val == "once" {
+		if len(tokens) > 1 {
+			if err := p.report(warningAt(tokens[1], "extra tokens at end of #pragma once directive")); err != nil {
+				return err
+			}
+		}
+		p.file.once = true
+		return nil
+	}
+
+	if p.keepPragmas {
+		// The pragma is read before the rest of the tokens.
+		p.sub = append([]*Token{pragmaToken(at, tokens)}, p.sub...)
+	}
+	if len(tokens) == 0 || tokens[0].Type != Identifier {
+		return nil
+	}
+
+	h, name, rest := p.pragmas.lookup(tokens)
+	if h == nil {
+		if p.warnUnknownPragmas {
+			return p.report(warningAt(tokens[0], "unknown pragma ignored"))
+		}
+		return nil
+	}
+	err := h(&Pragma{
+		Name:   name,
+		Tokens: rest,
+		Pos:    at.Position(),
+		state:  p.pragmaState,
+	})
+	if err == nil {
+		return nil
+	}
+	d, ok := err.(*diag.Diagnostic)
+	if !ok {
+		d = errorAt(at, "%s", err.Error())
+	}
+	return p.report(d)
+}
+
+// pragmaToken returns a PragmaDirective token of the pragma tokens at the
+// position of at.
+func pragmaToken(at *Token, tokens []*Token) *Token {
+	s := "#pragma"
+	for i, t := range tokens {
+		if i == 0 || !t.Adjacent {
+			s += " "
+		}
+		s += t.Raw
+	}
+	return &Token{
+		Type:         PragmaDirective,
+		Val:          s,
+		Raw:          s,
+		Pos:          at.Pos,
+		ExpansionPos: at.ExpansionPos,
+		expansions:   at.expansions,
+	}
+}
+
+// processPragma processes #pragma.
+func (p *preprocessor) processPragma(dir *Token) error {
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	return p.handlePragma(dir, line)
+}
+
+// processPragmaOperator processes the _Pragma operator. The operand is read
+// from the macro-expanded tokens.
+//
+// "6.10.9 Pragma operator" [spec]
+func (p *preprocessor) processPragmaOperator(op *Token) error {
+	var ts []*Token
+	for len(ts) < 3 {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t == nil || t.Type == '\n' {
+			continue
+		}
+		if t.Type == EOF {
+			// Keep the EOF to be read again.
+			p.sub = append(p.sub, t)
+			break
+		}
+		if len(ts) == 0 && t.Type != '(' {
+			// Keep the token to be read again.
+			p.sub = append([]*Token{t}, p.sub...)
+			break
+		}
+		ts = append(ts, t)
+	}
+	if len(ts) < 1 || ts[0].Type != '(' {
+		return errorAt(op, "_Pragma takes a parenthesized string literal")
+	}
+	if len(ts) < 2 || ts[1].Type != StringLiteral {
+		return errorAt(op, "_Pragma takes a parenthesized string literal")
+	}
+	if len(ts) < 3 || ts[2].Type != ')' {
+		return errorAt(op, "_Pragma takes a parenthesized string literal")
+	}
+
+	// "The string literal is destringized by deleting any encoding prefix,
+	// deleting the leading and trailing double-quotes, replacing each escape
+	// sequence \" by a double-quote, and replacing each escape sequence \\ by a
+	// single backslash." [spec]
+	raw := ts[1].Raw
+	raw = raw[strings.Index(raw, `"`)+1 : len(raw)-1]
+	raw = strings.Replace(raw, `\"`, `"`, -1)
+	raw = strings.Replace(raw, `\\`, `\`, -1)
+
+	t := &tokenizer{
+		src: newSource([]byte(raw), op.Pos.Filename),
+	}
+	var tokens []*Token
+	for {
+		tk, err := t.NextPPToken()
+		if err != nil {
+			return errorAt(op, "%s", strings.TrimPrefix(err.Error(), "lex: "))
+		}
+		if tk.Type == '\n' || tk.Type == EOF {
+			break
+		}
+		tk.Pos = op.Pos
+		tk.ExpansionPos = op.ExpansionPos
+		tk.expansions = op.expansions
+		tokens = append(tokens, tk)
+	}
+	return p.handlePragma(op, tokens)
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+// Standard represents a version of the C standard.
+type Standard int
+
+const (
+	// C89 represents ANSI C89 or ISO C90.
+	C89 Standard = iota + 1
+	C94
+	C99
+	C11
+	C17
+	C23
+)
+
+// DefaultStandard is the default version of the C standard.
+const DefaultStandard = C11
+
+// isValid reports whether s is one of the defined versions.
+func (s Standard) isValid() bool {
+	return C89 <= s && s <= C23
+}
+
+// version returns the value of __STDC_VERSION__, or an empty string if
+// __STDC_VERSION__ is not defined.
+func (s Standard) version() string {
+	switch s {
+	case C89:
+		return ""
+	case C94:
+		return "199409L"
+	case C99:
+		return "199901L"
+	case C11:
+		return "201112L"
+	case C17:
+		return "201710L"
+	case C23:
+		return "202311L"
+	}
+	panic("not reached")
+}
+
+// builtinFilename is the file name of the tokens of predefined macros.
+const builtinFilename = "<built-in>"
+
+// newStringLiteral returns a string literal token whose value is val.
+func newStringLiteral(val string) *Token {
+	raw := `"` + strings.Replace(strings.Replace(val, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+	return &Token{
+		Type:  StringLiteral,
+		Val:   val,
+		Raw:   raw,
+		Units: byteUnits(val),
+	}
+}
+
+// newPPNumber returns a pp-number token.
+func newPPNumber(val string) *Token {
+	return &Token{
+		Type: PPNumber,
+		Val:  val,
+		Raw:  val,
+	}
+}
+
+// predefinedMacros returns the predefined macros.
+//
+// "6.10.8 Predefined macro names" [spec]
+func predefinedMacros(opts *Options) map[string]macro {
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	// The date and the time of translation are fixed during preprocessing.
+	t := now()
+
+	std := opts.Std
+	if std == 0 {
+		std = DefaultStandard
+	}
+
+	ms := map[string]macro{}
+	define := func(name string, t *Token) {
+		t.Pos.Filename = builtinFilename
+		ms[name] = macro{
+			name:      name,
+			tokens:    []*Token{t},
+			paramsLen: -1,
+			pos:       srcpos.Position{Filename: builtinFilename},
+			body:      []*Token{t},
+		}
+	}
+	defineBuiltin := func(name string, f func(name *Token) *Token) {
+		ms[name] = macro{
+			name:      name,
+			paramsLen: -1,
+			pos:       srcpos.Position{Filename: builtinFilename},
+			builtin:   f,
+		}
+	}
+
+	// "The presumed date of translation of the preprocessing translation unit: a
+	// character string literal of the form "Mmm dd yyyy" ... If the day of the
+	// month is less than 10, it is padded with a space on the left." [spec]
+	define("__DATE__", newStringLiteral(fmt.Sprintf("%s %2d %d", t.Format("Jan"), t.Day(), t.Year())))
+	define("__TIME__", newStringLiteral(t.Format("15:04:05")))
+
+	defineBuiltin("__FILE__", func(name *Token) *Token {
+		return newStringLiteral(name.Position().Filename)
+	})
+	defineBuiltin("__LINE__", func(name *Token) *Token {
+		return newPPNumber(strconv.Itoa(name.Position().Line))
+	})
+	counter := 0
+	defineBuiltin("__COUNTER__", func(name *Token) *Token {
+		t := newPPNumber(strconv.Itoa(counter))
+		counter++
+		return t
+	})
+
+	define("__STDC__", newPPNumber("1"))
+	if opts.Freestanding {
+		define("__STDC_HOSTED__", newPPNumber("0"))
+	} else {
+		define("__STDC_HOSTED__", newPPNumber("1"))
+	}
+	if v := std.version(); v != "" {
+		define("__STDC_VERSION__", newPPNumber(v))
+	}
+	return ms
+}
@@ -15,138 +15,303 @@
 package preprocess
 
 import (
-	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/srcpos"
 )
 
 type ppTokenBufReader struct {
 	tokens []*Token
 	pos    int
+
+	// lineDelta and filename remap the positions of the tokens by #line.
+	// If filename is empty, the file name is not remapped.
+	lineDelta int
+	filename  string
+
+	// report reports the errors of the tokens that are read, or nil if the
+	// errors are not reported.
+	report func(error) error
+}
+
+// eof returns an EOF token at the position of the last token.
+func (t *ppTokenBufReader) eof() *Token {
+	tk := &Token{
+		Type: EOF,
+	}
+	if len(t.tokens) > 0 {
+		tk.Pos = t.tokens[len(t.tokens)-1].Pos
+	}
+	return t.remap(tk)
+}
+
+// remap returns the token with the position remapped by #line.
+// The original token is not modified as the tokens can be shared.
+func (t *ppTokenBufReader) remap(tk *Token) *Token {
+	if t.lineDelta == 0 && t.filename == "" {
+		return tk
+	}
+	if !tk.Pos.IsValid() {
+		return tk
+	}
+	c := *tk
+	c.Pos.Line += t.lineDelta
+	if t.filename != "" {
+		c.Pos.Filename = t.filename
+	}
+	return &c
 }
 
+// NextPPToken returns the next token. Comments are skipped.
+//
+// The error of the token found by tokenization is reported.
 func (t *ppTokenBufReader) NextPPToken() (*Token, error) {
+	tk := t.skipPPToken()
+	if tk.err != nil && t.report != nil {
+		if err := t.report(errorAt(tk, "%s", strings.TrimPrefix(tk.err.Error(), "lex: "))); err != nil {
+			return nil, err
+		}
+	}
+	return tk, nil
+}
+
+// skipPPToken is like NextPPToken, but for a token in a skipped group. A
+// skipped group only needs to consist of valid preprocessing tokens, and no
+// errors are reported.
+func (t *ppTokenBufReader) skipPPToken() *Token {
+	t.skipComments()
 	if t.pos >= len(t.tokens) {
-		return &Token{
-			Type: EOF,
-		}, nil
+		return t.eof()
 	}
 	tk := t.tokens[t.pos]
 	t.pos++
-	return tk, nil
+	return t.remap(tk)
 }
 
 func (t *ppTokenBufReader) peekPPToken() (*Token, error) {
+	t.skipComments()
 	if t.pos >= len(t.tokens) {
-		return &Token{
-			Type: EOF,
-		}, nil
+		return t.eof(), nil
 	}
-	return t.tokens[t.pos], nil
+	return t.remap(t.tokens[t.pos]), nil
 }
 
-func (t *ppTokenBufReader) AtLineHead() bool {
-	if t.pos == 0 {
-		return true
+// nextComment returns the next token if it is a comment, or nil otherwise.
+func (t *ppTokenBufReader) nextComment() *Token {
+	if t.pos >= len(t.tokens) || t.tokens[t.pos].Type != Comment {
+		return nil
 	}
-	if t.tokens[t.pos-1].Type == '\n' {
-		return true
-	}
-	return false
-}
-
-type ppTokenSliceReader struct {
-	tokens []*Token
-	pos    int
+	tk := t.tokens[t.pos]
+	t.pos++
+	return t.remap(tk)
 }
 
-func (t *ppTokenSliceReader) NextPPToken() (*Token, error) {
-	if len(t.tokens) <= t.pos {
-		return &Token{
-			Type: EOF,
-		}, nil
+func (t *ppTokenBufReader) skipComments() {
+	for t.pos < len(t.tokens) && t.tokens[t.pos].Type == Comment {
+		t.pos++
 	}
-	tk := t.tokens[t.pos]
-	t.pos++
-	return tk, nil
 }
 
-func (t *ppTokenSliceReader) peekPPToken() (*Token, error) {
-	if len(t.tokens) <= t.pos {
-		return &Token{
-			Type: EOF,
-		}, nil
+func (t *ppTokenBufReader) AtLineHead() bool {
+	// Comments before the next token are regarded as white-spaces.
+	i := t.pos
+	for i > 0 && t.tokens[i-1].Type == Comment {
+		i--
+	}
+	if i == 0 {
+		return true
 	}
-	return t.tokens[t.pos], nil
+	if t.tokens[i-1].Type == '\n' {
+		return true
+	}
+	return false
 }
 
 type preprocessor struct {
 	src  *ppTokenBufReader
 	path string
+	file *file
+
+	files    *fileCache
+	resolver *includeResolver
+	sub      []*Token
+	macros   map[string]macro
+	conds    []*condition
+
+	// diags is the collector of the recoverable errors shared by all the
+	// preprocessors.
+	diags *diag.Collector
+
+	// dirIndex is the index of the search directory where the current file was
+	// found, or -1 otherwise. This is used for #include_next.
+	dirIndex int
+
+	// includedFrom is the include backtrace of the current file. The innermost
+	// frame comes first. This also works as the include stack.
+	includedFrom []diag.Frame
+
+	// pragmas is the pragma handlers.
+	pragmas *PragmaRegistry
 
-	tokens  map[string][]*Token
-	sub     []*Token
-	visited map[string]struct{}
-	macros  map[string]macro
+	// warnUnknownPragmas indicates whether unknown pragmas are warned.
+	warnUnknownPragmas bool
+
+	// keepPragmas indicates whether pragmas are passed through as
+	// PragmaDirective tokens.
+	keepPragmas bool
+
+	// pragmaState is the state of the pragmas shared by all the
+	// preprocessors.
+	pragmaState *pragmaState
+
+	// included is the preprocessor of the file being included.
+	included *preprocessor
+
+	// maxIncludeDepth is the maximum depth of nested #include.
+	maxIncludeDepth int
+
+	// directiveLine indicates that src is a line of a directive that is being
+	// macro-expanded. No directives are processed in this case.
+	directiveLine bool
+
+	// observer is notified when included files are entered and left, or nil.
+	observer fileObserver
+
+	// deps records the included files, or nil.
+	deps *Dependencies
+
+	// attributes and builtins are the tables for __has_attribute and
+	// __has_builtin.
+	attributes map[string]int
+	builtins   map[string]bool
 }
 
-func (p *preprocessor) NextPPToken() (*Token, error) {
-	if p.src == nil {
-		ts, ok := p.tokens[p.path]
-		if !ok {
-			return nil, fmt.Errorf("preprocess: file not found: %s", p.path)
+// fileObserver observes the included files during preprocessing.
+//
+// The notifications are in sync with the token stream: enterFile is called
+// before the first token of the included file is returned, and leaveFile is
+// called after the last one.
+type fileObserver interface {
+	// enterFile is called when the included file of path is entered. system
+	// indicates whether the file is found in a system directory.
+	enterFile(path string, system bool)
+
+	// leaveFile is called when the included file is left. at is the position
+	// of the #include directive.
+	leaveFile(at srcpos.Position)
+}
+
+// load loads the tokens of the current file. at is the header name token of
+// the #include directive, or nil for the main file.
+func (p *preprocessor) load(at *Token) error {
+	f, err := p.files.load(p.path)
+	if l, ok := err.(diag.List); ok {
+		for _, d := range l {
+			if err := p.report(d); err != nil {
+				return err
+			}
 		}
-		p.src = &ppTokenBufReader{
-			tokens: ts,
+		err = nil
+	}
+	if err != nil {
+		if at == nil {
+			return diag.Fatalf(srcpos.Position{}, "%s: %v", p.path, err)
 		}
+		return fatalAt(at, "%s: %v", at.Val, err)
+	}
+	p.file = f
+	p.src = &ppTokenBufReader{
+		tokens: f.tokens,
+		report: p.report,
 	}
+	return nil
+}
 
+func (p *preprocessor) NextPPToken() (*Token, error) {
 	for {
 		t, err := p.next()
-		if t == nil && err == nil {
+		if err != nil {
+			if err := p.report(err); err != nil {
+				return nil, err
+			}
 			continue
 		}
-		if err != nil {
-			return nil, err
+		if t == nil {
+			continue
 		}
 		// A new-line char is only for preprocessing. Discard it.
 		if t.Type == '\n' {
 			continue
 		}
+		if t.Type == Identifier && t.Val == "_Pragma" && !p.directiveLine {
+			if err := p.processPragmaOperator(t); err != nil {
+				if err := p.report(err); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
 		return t, err
 	}
 }
 
-func (p *preprocessor) next() (*Token, error) {
-	if len(p.sub) > 0 {
-		t := p.sub[0]
-		p.sub = p.sub[1:]
-
-		if t.Type != Identifier {
-			return t, nil
+// report reports the error err to the collector if err is recoverable, and
+// returns nil. Otherwise, report returns err as it is.
+func (p *preprocessor) report(err error) error {
+	d, ok := err.(*diag.Diagnostic)
+	if !ok {
+		return err
+	}
+	// Add the include backtrace unless the error comes from an included file.
+	hasIncludedFrom := false
+	for _, f := range d.Backtrace {
+		if f.Kind == diag.IncludedFrom {
+			hasIncludedFrom = true
+			break
 		}
+	}
+	if !hasIncludedFrom {
+		d.Backtrace = append(d.Backtrace, p.includedFrom...)
+	}
+	if d.Severity == diag.Fatal {
+		return d
+	}
+	p.diags.Report(d)
+	return nil
+}
 
-		m, ok := p.macros[t.Val]
-		if !ok {
+func (p *preprocessor) next() (*Token, error) {
+	// The tokens of the included file are already preprocessed and must not be
+	// rescanned.
+	if p.included != nil {
+		t, err := p.included.NextPPToken()
+		if err != nil {
+			return nil, err
+		}
+		if t.Type != EOF {
 			return t, nil
 		}
-
-		// The token came from the same macro.
-		if t.ExpandedFrom != nil {
-			if _, ok := t.ExpandedFrom[m.name]; ok {
-				return t, nil
-			}
+		if p.observer != nil && len(p.included.includedFrom) > 0 {
+			p.observer.leaveFile(p.included.includedFrom[0].Pos)
 		}
+		p.included = nil
+	}
 
+	if len(p.sub) > 0 {
+		t := p.sub[0]
+		p.sub = p.sub[1:]
 		// "6.10.3.4 Rescanning and further replacement" [spec]
-		src := &ppTokenSliceReader{
-			tokens: p.sub,
-			pos:    0,
-		}
-		tks, err := m.apply(src, t.ExpandedFrom)
-		if err != nil {
-			return nil, err
+		return p.expandMacro(t)
+	}
+
+	if !p.directiveLine {
+		if t := p.src.nextComment(); t != nil {
+			return t, nil
 		}
-		p.sub = append(tks, p.sub[src.pos:]...)
-		return nil, nil
 	}
 
 	wasLineHead := p.src.AtLineHead()
@@ -158,246 +323,803 @@ func (p *preprocessor) next() (*Token, error) {
 
 	switch t.Type {
 	case Identifier:
-		m, ok := p.macros[t.Val]
-		if !ok {
+		return p.expandMacro(t)
+	case '#':
+		if !wasLineHead || p.directiveLine {
 			return t, nil
 		}
-		tks, err := m.apply(p.src, nil)
-		if err != nil {
+		if err := p.processDirective(t); err != nil {
+			// Skip the rest of the directive line.
+			if !p.src.AtLineHead() {
+				if _, err := p.readLine(); err != nil {
+					return nil, err
+				}
+			}
 			return nil, err
 		}
-		p.sub = tks
-	case '#':
-		if !wasLineHead {
-			return t, nil
+	case EOF:
+		p.reportUnterminated()
+		return t, nil
+	default:
+		return t, nil
+	}
+
+	// Preprocessing derective is processed correctly.
+	// There is no token to return.
+	return nil, nil
+}
+
+// expandMacro expands the macro invoked by the given token. If the token
+// does not invoke a macro, expandMacro returns the token as it is. Otherwise,
+// the resulting tokens are pushed back to be rescanned with the rest of the
+// tokens, and expandMacro returns nil.
+func (p *preprocessor) expandMacro(t *Token) (*Token, error) {
+	if t.Type != Identifier {
+		return t, nil
+	}
+	m, ok := p.macros[t.Val]
+	if !ok {
+		return t, nil
+	}
+	// The token came from the same macro.
+	if _, ok := t.ExpandedFrom[m.name]; ok {
+		return t, nil
+	}
+	// "Each subsequent instance of the function-like macro name followed by
+	// a ( as the next preprocessing token introduces the sequence of
+	// preprocessing tokens that is replaced by the replacement list in the
+	// definition" [spec]
+	if m.isFuncLike() && !p.nextIsLParen() {
+		return t, nil
+	}
+
+	tks, err := m.apply(p, t)
+	if err != nil {
+		return nil, err
+	}
+	// The first resulting token takes over the white-space before the macro
+	// name.
+	if len(tks) > 0 {
+		tks[0].Adjacent = t.Adjacent
+	}
+	p.sub = append(tks, p.sub...)
+	return nil, nil
+}
+
+// nextIsLParen reports whether the next token except for new-lines is '('.
+// The tokens produced by macro expansions are followed by the tokens in the
+// source.
+func (p *preprocessor) nextIsLParen() bool {
+	for _, t := range p.sub {
+		if t.Type == '\n' {
+			continue
 		}
-		// The tokens must end with '\n', so nil check is not needed.
-		t, err := p.src.NextPPToken()
+		return t.Type == '('
+	}
+	for i := p.src.pos; i < len(p.src.tokens); i++ {
+		t := p.src.tokens[i]
+		if t.Type == Comment {
+			continue
+		}
+		if t.Type == '\n' {
+			// A directive line cannot be a part of a macro invocation.
+			if !p.directiveLine && i+1 < len(p.src.tokens) && p.src.tokens[i+1].Type == '#' {
+				return false
+			}
+			continue
+		}
+		return t.Type == '('
+	}
+	return false
+}
+
+// expansionReader reads the tokens to rescan, which are the tokens produced by
+// macro expansions followed by the tokens in the source. New-lines are skipped
+// as they are regarded as white-spaces in macro invocations.
+type expansionReader struct {
+	p *preprocessor
+}
+
+func (e *expansionReader) NextPPToken() (*Token, error) {
+	for {
+		t, err := e.p.nextUnexpanded()
 		if err != nil {
 			return nil, err
 		}
 		if t.Type == '\n' {
-			// Empty directive
-			return t, nil
+			continue
 		}
-		if t.Type != Identifier {
-			return nil, fmt.Errorf("preprocess: expected %s but %s", Identifier, t.Type)
+		return t, nil
+	}
+}
+
+// processDirective processes the directive beginning with the given '#' token.
+func (p *preprocessor) processDirective(hash *Token) error {
+	// The tokens must end with '\n', so nil check is not needed.
+	t, err := p.src.NextPPToken()
+	if err != nil {
+		return err
+	}
+	if t.Type == '\n' {
+		// Empty directive
+		return nil
+	}
+	if t.Type != Identifier {
+		return errorAt(t, "invalid preprocessing directive")
+	}
+	switch t.Val {
+	case "define":
+		t, err := nextExpected(p.src, Identifier)
+		if err != nil {
+			return err
 		}
-		switch t.Val {
-		case "define":
-			t, err := nextExpected(p.src, Identifier)
-			if err != nil {
-				return nil, err
-			}
-			name := t.Val
-			// TODO: What if the same macro is redefined?
+		name := t
 
-			paramsLen := -1
-			var params []string
-			t, err = p.src.peekPPToken()
+		var params []string
+		variadic := false
+		t, err = p.src.peekPPToken()
+		if err != nil {
+			return err
+		}
+		if t.Type == '(' && t.Adjacent {
+			if _, err := nextExpected(p.src, '('); err != nil {
+				panic("not reached")
+			}
+			params = []string{}
+			t, err := p.src.peekPPToken()
 			if err != nil {
-				return nil, err
+				return err
 			}
-			if t.Type == '(' && t.Adjacent {
-				if _, err := nextExpected(p.src, '('); err != nil {
+			if t.Type == ')' {
+				if _, err := nextExpected(p.src, ')'); err != nil {
 					panic("not reached")
 				}
-				params = []string{}
-				t, err := p.src.peekPPToken()
-				if err != nil {
-					return nil, err
-				}
-				if t.Type == ')' {
-					if _, err := nextExpected(p.src, ')'); err != nil {
-						panic("not reached")
+			} else {
+				for {
+					t, err := nextExpected(p.src, Identifier, DotDotDot)
+					if err != nil {
+						return err
 					}
-				} else {
-					for {
-						t, err := nextExpected(p.src, Identifier)
-						if err != nil {
-							return nil, err
-						}
-						params = append(params, t.Val)
-						t, err = nextExpected(p.src, ')', ',')
-						if err != nil {
-							return nil, err
-						}
-						if t.Type == ')' {
-							break
+					if t.Type == DotDotDot {
+						// "6.10.3.1 Argument substitution" [spec]
+						// The variable arguments are treated as the last parameter
+						// named __VA_ARGS__.
+						variadic = true
+						params = append(params, "__VA_ARGS__")
+						if _, err := nextExpected(p.src, ')'); err != nil {
+							return err
 						}
+						break
+					}
+					if t.Val == "__VA_ARGS__" || t.Val == "__VA_OPT__" {
+						return errorAt(t, "%s can not be used as a parameter name", t.Val)
+					}
+					params = append(params, t.Val)
+					t, err = nextExpected(p.src, ')', ',')
+					if err != nil {
+						return err
+					}
+					if t.Type == ')' {
+						break
 					}
 				}
-				paramsLen = len(params)
 			}
+		}
 
-			ts := []*Token{}
-			for {
-				t, err := p.src.NextPPToken()
-				if err != nil {
-					return nil, err
-				}
-				if t.Type == '\n' {
-					break
-				}
-				ts = append(ts, t)
-			}
+		ts, err := p.readLine()
+		if err != nil {
+			return err
+		}
 
-			// Replace parameter identifier-like tokens with Param tokens.
-			if paramsLen >= 0 {
-				ts2 := []*Token{}
-				for i := 0; i < len(ts); i++ {
-					t := ts[i]
-					switch t.Type {
-					case '#':
-						i++
-						if i >= len(ts) {
-							return nil, fmt.Errorf("preprocess: '#' is not followed by a macro parameter")
-						}
-						t := ts[i]
-						if t.Type != Identifier {
-							return nil, fmt.Errorf("preprocess: '#' is not followed by a macro parameter")
-						}
-						idx := -1
-						for i, p := range params {
-							if t.Val == p {
-								idx = i
-								break
-							}
-						}
-						if idx == -1 {
-							return nil, fmt.Errorf("preprocess: '#' is not followed by a macro parameter")
-						}
-						ts2 = append(ts2, &Token{
-							Type:       Param,
-							ParamIndex: idx,
-							ParamHash:  true,
-						})
-					case Identifier:
-						idx := -1
-						for i, p := range params {
-							if t.Val == p {
-								idx = i
-								break
-							}
-						}
-						if idx != -1 {
-							ts2 = append(ts2, &Token{
-								Type:       Param,
-								ParamIndex: idx,
-							})
-						} else {
-							ts2 = append(ts2, t)
-						}
-					default:
-						ts2 = append(ts2, t)
-					}
-				}
-				ts = ts2
+		m, err := newMacro(name, params, variadic, ts)
+		if err != nil {
+			return err
+		}
+		// "An identifier currently defined as an object-like macro shall not be
+		// redefined by another #define preprocessing directive unless the
+		// second definition is an object-like macro definition and the two
+		// replacement lists are identical." [spec]
+		// A different redefinition is reported as a warning as GCC and Clang
+		// do, and the new definition is used.
+		if old, ok := p.macros[name.Val]; ok && !old.identical(&m) {
+			d := warningAt(name, "macro \"%s\" redefined", name.Val)
+			d.Notes = append(d.Notes, diag.Notef(old.pos, "previous definition is here"))
+			if err := p.report(d); err != nil {
+				return err
 			}
+		}
+		p.macros[name.Val] = m
+	case "undef":
+		t, err := nextExpected(p.src, Identifier)
+		if err != nil {
+			return err
+		}
+		delete(p.macros, t.Val)
+		if _, err := nextExpected(p.src, '\n'); err != nil {
+			return err
+		}
+	case "include", "include_next":
+		return p.processInclude(hash, t)
+	case "if", "ifdef", "ifndef":
+		return p.processIf(hash, t)
+	case "elif", "else":
+		return p.processElse(hash, t)
+	case "endif":
+		return p.processEndif(hash)
+	case "line":
+		return p.processLine(t)
+	case "pragma":
+		return p.processPragma(t)
+	case "error", "warning":
+		return p.processMessage(hash, t)
+	case "embed":
+		return p.processEmbed(hash, t)
+	default:
+		return errorAt(t, "invalid preprocessing directive #%s", t.Val)
+	}
+	return nil
+}
+
+// processMessage processes #error or #warning. #error reports an error, and
+// #warning reports a warning. The message is the spelling of the rest of the
+// line.
+func (p *preprocessor) processMessage(hash *Token, dir *Token) error {
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	msg := "#" + dir.Val
+	if len(line) > 0 {
+		msg += " " + spelling(line)
+	}
+	if dir.Val == "warning" {
+		return p.report(warningAt(hash, "%s", msg))
+	}
+	return errorAt(hash, "%s", msg)
+}
+
+// spelling returns the spelling of the tokens in the source. The tokens are
+// separated by a space where white-spaces are between them.
+func spelling(tokens []*Token) string {
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 && !t.Adjacent {
+			b.WriteString(" ")
+		}
+		b.WriteString(t.Raw)
+	}
+	return b.String()
+}
+
+// processInclude processes #include or #include_next.
+func (p *preprocessor) processInclude(hash *Token, dir *Token) error {
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	// "A preprocessing directive of the form
+	//   # include pp-tokens new-line
+	// (that does not match one of the two previous forms) is permitted. The
+	// preprocessing tokens after include in the directive are processed just
+	// as in normal text." [spec]
+	// An invalid header name is already reported.
+	if len(line) > 0 && line[0].err != nil {
+		return nil
+	}
+	if len(line) > 0 && line[0].Type != HeaderName {
+		line, err = p.expandLine(line, false)
+		if err != nil {
+			return err
+		}
+	}
+	t, rest, err := headerName(dir, "#"+dir.Val, line)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errorAt(rest[0], "extra tokens at end of #%s directive", dir.Val)
+	}
+
+	next := dir.Val == "include_next"
+	if next && len(p.includedFrom) == 0 {
+		// GCC warns in this case. Search from the first directory.
+		next = false
+	}
+	quoted := t.Raw[0] == '"'
+	path, dirIndex, ok := p.resolver.resolve(t.Val, quoted, p.path, next, p.dirIndex)
+	if !ok {
+		return fatalAt(t, "'%s' file not found", t.Val)
+	}
+	if p.deps != nil {
+		p.deps.add(path, p.resolver.isSystemDir(dirIndex))
+	}
+	// A file with #pragma once or an include guard is not read again.
+	if f := p.files.cached(path); f != nil {
+		if f.once {
+			return nil
+		}
+		if _, ok := p.macros[f.guard]; ok && f.guard != "" {
+			return nil
+		}
+	}
+	// A file can be included repeatedly or recursively. Only the depth is
+	// limited to avoid infinite recursion.
+	if len(p.includedFrom) >= p.maxIncludeDepth {
+		return errorAt(t, "#include nested depth %d exceeds maximum of %d", len(p.includedFrom)+1, p.maxIncludeDepth)
+	}
+	ts := &preprocessor{
+		path:         path,
+		files:        p.files,
+		resolver:     p.resolver,
+		macros:       p.macros,
+		diags:        p.diags,
+		dirIndex:     dirIndex,
+		includedFrom: append([]diag.Frame{{Kind: diag.IncludedFrom, Pos: hash.Pos}}, p.includedFrom...),
 
-			p.macros[name] = macro{
-				name:      name,
-				tokens:    ts,
-				paramsLen: paramsLen,
+		pragmas:            p.pragmas,
+		warnUnknownPragmas: p.warnUnknownPragmas,
+		keepPragmas:        p.keepPragmas,
+		pragmaState:        p.pragmaState,
+		maxIncludeDepth:    p.maxIncludeDepth,
+		observer:           p.observer,
+		deps:               p.deps,
+		attributes:         p.attributes,
+		builtins:           p.builtins,
+	}
+	if err := ts.load(t); err != nil {
+		return err
+	}
+	p.included = ts
+	if p.observer != nil {
+		p.observer.enterFile(path, p.resolver.isSystemDir(dirIndex))
+	}
+	return nil
+}
+
+// headerName returns the header name at the beginning of tokens, and the
+// rest of the tokens. op is the directive name token or the operator token
+// like __has_include, and name is its name used in diagnostics.
+//
+// The macro-expanded tokens are reinterpreted as a header name: a string
+// literal without a prefix is regarded as the "..." form, and the tokens
+// between < and > are combined into the <...> form.
+//
+// "The directive resulting after all replacements shall match one of the two
+// previous forms. The method by which a sequence of preprocessing tokens
+// between a < and a > preprocessing token pair or a pair of " characters is
+// combined into a single header name preprocessing token is
+// implementation-defined." [spec]
+func headerName(op *Token, name string, tokens []*Token) (*Token, []*Token, error) {
+	if len(tokens) == 0 {
+		return nil, nil, errorAt(op, "%s expects \"FILENAME\" or <FILENAME>", name)
+	}
+	t := tokens[0]
+	var h *Token
+	rest := tokens[1:]
+	switch {
+	case t.Type == HeaderName:
+		h = t
+	case t.Type == StringLiteral && strings.HasPrefix(t.Raw, `"`):
+		// Escape sequences are not processed in header names.
+		h = &Token{
+			Type: HeaderName,
+			Val:  t.Raw[1 : len(t.Raw)-1],
+			Raw:  t.Raw,
+		}
+	case t.Type == '<':
+		// Like GCC, the spellings of the tokens are combined with a space for
+		// white-spaces between them.
+		val := ""
+		for i, t := range tokens[1:] {
+			if t.Type == '>' {
+				h = &Token{
+					Type: HeaderName,
+					Val:  val,
+					Raw:  "<" + val + ">",
+				}
+				rest = tokens[i+2:]
+				break
 			}
-		case "undef":
-			t, err := nextExpected(p.src, Identifier)
-			if err != nil {
-				return nil, err
+			if !t.Adjacent && i > 0 {
+				val += " "
 			}
-			delete(p.macros, t.Val)
-			if _, err := nextExpected(p.src, '\n'); err != nil {
-				return nil, err
+			val += t.Raw
+		}
+		if h == nil {
+			return nil, nil, errorAt(t, "missing terminating > character")
+		}
+	default:
+		return nil, nil, errorAt(t, "%s expects \"FILENAME\" or <FILENAME>", name)
+	}
+	if h != t {
+		h.Pos = t.Pos
+		h.ExpansionPos = t.ExpansionPos
+		h.expansions = t.expansions
+	}
+	if h.Val == "" {
+		return nil, nil, errorAt(t, "empty filename in %s", name)
+	}
+	return h, rest, nil
+}
+
+// maxLineNumber is the maximum line number that can be specified by #line.
+const maxLineNumber = 2147483647
+
+// processLine processes #line.
+//
+// "6.10.4 Line control" [spec]
+func (p *preprocessor) processLine(dir *Token) error {
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	// "The preprocessing tokens after line on the directive are processed just
+	// as in normal text" [spec]
+	ts, err := p.expandLine(line, false)
+	if err != nil {
+		return err
+	}
+
+	if len(ts) == 0 {
+		return errorAt(dir, "#line directive requires a positive integer argument")
+	}
+	// "The digit sequence shall not specify zero, nor a number greater than
+	// 2147483647." [spec]
+	// The digit sequence is interpreted as a decimal integer even if it starts
+	// with 0.
+	num := ts[0]
+	if num.Type != PPNumber || strings.Trim(num.Val, "0123456789") != "" {
+		return errorAt(num, "#line directive requires a positive integer argument")
+	}
+	n, err := strconv.ParseInt(num.Val, 10, 64)
+	if err != nil || n > maxLineNumber {
+		return errorAt(num, "line number out of range")
+	}
+	if n == 0 {
+		if err := p.report(warningAt(num, "#line directive with zero argument")); err != nil {
+			return err
+		}
+	}
+
+	filename := ""
+	if len(ts) > 1 {
+		if t := ts[1]; t.Type != StringLiteral || t.Raw[0] != '"' {
+			return errorAt(t, "invalid filename for #line directive")
+		}
+		filename = ts[1].Val
+	}
+	if len(ts) > 2 {
+		if err := p.report(warningAt(ts[2], "extra tokens at end of #line directive")); err != nil {
+			return err
+		}
+	}
+
+	// The new-line token ending the directive is the last read token.
+	if p.src.pos == 0 || p.src.pos > len(p.src.tokens) {
+		return nil
+	}
+	nl := p.src.tokens[p.src.pos-1]
+	if nl.Type != '\n' {
+		return nil
+	}
+	// "causes the implementation to behave as if the following sequence of
+	// source lines begins with a source line that has a line number as
+	// specified by the digit sequence" [spec]
+	p.src.lineDelta = int(n) - (nl.Pos.Line + 1)
+	if filename != "" {
+		p.src.filename = filename
+	}
+	return nil
+}
+
+// readLine reads the tokens until the end of the current line.
+// The last new-line token is consumed but not included in the result.
+func (p *preprocessor) readLine() ([]*Token, error) {
+	ts := []*Token{}
+	for {
+		t, err := p.src.NextPPToken()
+		if err != nil {
+			return nil, err
+		}
+		if t.Type == '\n' || t.Type == EOF {
+			break
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// expandLine macro-expands the tokens of a directive line.
+//
+// If inCond is true, the line is treated as a controlling expression of #if
+// or #elif, and the defined operators and the operators like __has_include
+// are replaced with pp-numbers without expanding their operands.
+func (p *preprocessor) expandLine(tokens []*Token, inCond bool) ([]*Token, error) {
+	e := &preprocessor{
+		src: &ppTokenBufReader{
+			tokens: tokens,
+		},
+		macros:        p.macros,
+		diags:         p.diags,
+		directiveLine: true,
+	}
+	ts := []*Token{}
+	for {
+		t, err := e.next()
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			continue
+		}
+		if t.Type == EOF {
+			break
+		}
+		if inCond && t.Type == Identifier {
+			var v *Token
+			switch t.Val {
+			case "defined":
+				v, err = e.evalDefined(t)
+			case "__has_include", "__has_include_next":
+				v, err = p.evalHasInclude(e, t)
+			case "__has_attribute", "__has_builtin":
+				v, err = p.evalHasFeature(e, t)
 			}
-		case "include":
-			t, err := nextExpected(p.src, HeaderName)
 			if err != nil {
 				return nil, err
 			}
-			path := t.Val
-			if _, ok := p.visited[path]; ok {
-				return nil, fmt.Errorf("preprocess: recursive #include: %s", path)
-			}
-			p.visited[path] = struct{}{}
-			ts := preprocessImpl(path, p.tokens, p.visited)
-			p.sub = []*Token{}
-			for {
-				t, err := ts.NextPPToken()
-				if err != nil {
-					return nil, err
-				}
-				if t.Type == EOF {
-					break
-				}
-				p.sub = append(p.sub, t)
+			if v != nil {
+				ts = append(ts, v)
+				continue
 			}
-		case "if":
-			return nil, fmt.Errorf("preprocess: #if is not implemented")
-		case "ifdef":
-			return nil, fmt.Errorf("preprocess: #ifdef is not implemented")
-		case "ifndef":
-			return nil, fmt.Errorf("preprocess: #ifndef is not implemented")
-		case "else":
-			return nil, fmt.Errorf("preprocess: #else is not implemented")
-		case "endif":
-			return nil, fmt.Errorf("preprocess: #line is not implemented")
-		case "line":
-			return nil, fmt.Errorf("preprocess: #line is not implemented")
-		case "elif":
-			return nil, fmt.Errorf("preprocess: #elif is not implemented")
-		case "pragma":
-			return nil, fmt.Errorf("preprocess: #pragma is not implemented")
-		case "error":
-			msg := ""
-			for {
-				t, err := p.src.NextPPToken()
-				if err != nil {
-					return nil, err
-				}
-				if t.Type == '\n' {
-					break
-				}
-				// TODO: Define RawString() and use it?
-				msg += " " + t.String()
-			}
-			return nil, fmt.Errorf("preprocess: #error" + msg)
-		default:
-			return nil, fmt.Errorf("preprocess: invalid preprocessing directive %s", t.Val)
 		}
-	default:
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// nextUnexpanded returns the next token without macro expansion.
+func (p *preprocessor) nextUnexpanded() (*Token, error) {
+	if len(p.sub) > 0 {
+		t := p.sub[0]
+		p.sub = p.sub[1:]
 		return t, nil
 	}
+	return p.src.NextPPToken()
+}
 
-	// Preprocessing derective is processed correctly.
-	// There is no token to return.
-	return nil, nil
+// Options represents options for preprocessing.
+type Options struct {
+	// FS is the file system where header names are resolved and files are read.
+	// If FS is nil, the file system consisting of the paths of the given
+	// tokens is used.
+	//
+	// As paths in FS are slash-separated and unrooted, the directories below
+	// must also be paths in FS, e.g. "usr/include" for os.DirFS("/").
+	FS fs.FS
+
+	// QuoteDirs are the directories searched for quoted header names, like
+	// -iquote.
+	QuoteDirs []string
+
+	// IncludeDirs are the directories searched for both forms of header names,
+	// like -I.
+	IncludeDirs []string
+
+	// SystemDirs are the system directories searched after IncludeDirs.
+	SystemDirs []string
+
+	// MaxIncludeDepth is the maximum depth of nested #include, like
+	// -fmax-include-depth. If MaxIncludeDepth is 0, DefaultMaxIncludeDepth is
+	// used.
+	MaxIncludeDepth int
+
+	// Std is the version of the C standard, which determines
+	// __STDC_VERSION__. If Std is 0, DefaultStandard is used. Std must be 0 or
+	// one of the defined versions.
+	Std Standard
+
+	// Freestanding indicates whether the implementation is a freestanding one.
+	// If true, __STDC_HOSTED__ is 0.
+	Freestanding bool
+
+	// Now returns the current time for __DATE__ and __TIME__. If Now is nil,
+	// time.Now is used.
+	Now func() time.Time
+
+	// Pragmas is the pragma handlers. If Pragmas is nil, the handlers of
+	// NewPragmaRegistry are used.
+	Pragmas *PragmaRegistry
+
+	// WarnUnknownPragmas indicates whether unknown pragmas are warned, like
+	// -Wunknown-pragmas.
+	WarnUnknownPragmas bool
+
+	// KeepPragmas indicates whether pragmas are passed through as
+	// PragmaDirective tokens so that the compiler can handle them. #pragma
+	// once is never passed through. WriteText always keeps pragmas.
+	KeepPragmas bool
+
+	// Diagnostics is the collector where all the diagnostics including
+	// warnings are reported. If Diagnostics is nil, only the errors are
+	// available as the returned error.
+	Diagnostics *diag.Collector
+
+	// MacroOptions are the options that define or undefine macros like -D
+	// and -U. They are processed in order before the main file, and the
+	// errors in them are reported at "<command line>".
+	MacroOptions []MacroOption
+
+	// ForceIncludes are the files included before the main file like
+	// -include. They are included in order after MacroOptions are processed.
+	// The paths must not have double quotes or new-lines.
+	ForceIncludes []string
+
+	// KeepComments indicates whether comments are kept in the result, like
+	// -C. Comments in directives and skipped groups are not kept.
+	//
+	// This affects only the files read from FS. The given tokens must be
+	// tokenized by TokenizeWithComments to keep their comments.
+	KeepComments bool
+
+	// Trigraphs indicates whether trigraph sequences are replaced, like
+	// -trigraphs. If Trigraphs is false, trigraph sequences are reported as
+	// warnings.
+	//
+	// This affects only the files read from FS.
+	Trigraphs bool
+
+	// Dependencies receives the header files included during preprocessing,
+	// like cc -M. If Dependencies is nil, the header files are not recorded.
+	Dependencies *Dependencies
+
+	// Attributes is the table of the supported attribute names and their
+	// versions for __has_attribute, like "deprecated": 201904. The names are
+	// without surrounding double underscores, and scoped ones are like
+	// "gnu::packed". If Attributes is nil, no attributes are supported as goc
+	// does not support attributes yet.
+	Attributes map[string]int
+
+	// Builtins is the set of the supported builtin names for __has_builtin.
+	// If Builtins is nil, no builtins are supported as goc does not support
+	// builtins yet.
+	Builtins map[string]bool
+
+	// Macros receives the macros defined at the end of preprocessing, like
+	// gcc -dM. If Macros is nil, the macros are not recorded.
+	Macros *MacroTable
 }
 
+// DefaultMaxIncludeDepth is the default maximum depth of nested #include.
+const DefaultMaxIncludeDepth = 200
+
+// Preprocess preprocesses the file of path. tokens is the map of file paths
+// and their preprocessing tokens.
+//
+// Header names are resolved in the paths of tokens, and the root directory is
+// used as the system directory.
+//
+// Preprocessing continues after recoverable errors. In this case, the tokens are
+// returned with an error of diag.List including all the errors.
 func Preprocess(path string, tokens map[string][]*Token) ([]*Token, error) {
+	return PreprocessWithOptions(path, tokens, &Options{
+		SystemDirs: []string{"."},
+	})
+}
+
+// PreprocessFS preprocesses the file of path in fsys with the options.
+// opts can be nil.
+//
+// Files are read from fsys and tokenized only when they are actually included.
+// The tokens of each file are cached by its resolved path.
+func PreprocessFS(fsys fs.FS, path string, opts *Options) ([]*Token, error) {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.FS = fsys
+	return PreprocessWithOptions(path, nil, &o)
+}
+
+// PreprocessWithOptions preprocesses the file of path with the options.
+// opts can be nil.
+// tokens is the map of resolved file paths and their preprocessing tokens.
+// Files not in tokens are read from opts.FS on demand.
+func PreprocessWithOptions(path string, tokens map[string][]*Token, opts *Options) ([]*Token, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	p, err := newPreprocessor(path, tokens, opts, nil)
+	if err != nil {
+		return nil, err
+	}
 	t := &stringConcatter{
-		src: preprocessImpl(path, tokens, map[string]struct{}{
-			path: {},
-		}),
+		src:   p,
+		diags: p.diags,
 	}
 	tks := []*Token{}
 	for {
 		tk, err := t.NextPPToken()
 		if err != nil {
-			return nil, err
+			p.diags.ReportError(err)
+			return nil, p.diags.Err()
 		}
 		if tk.Type == EOF {
 			break
 		}
 		tks = append(tks, tk)
 	}
-	return tks, nil
+	if opts.Macros != nil {
+		opts.Macros.set(p.macros)
+	}
+	return tks, p.diags.Err()
 }
 
-func preprocessImpl(path string, tokens map[string][]*Token, visited map[string]struct{}) PPTokenReader {
-	return &preprocessor{
-		path:    path,
-		tokens:  tokens,
-		visited: visited,
-		macros:  map[string]macro{},
+// newPreprocessor creates a preprocessor of the main file of path with the
+// options. observer can be nil.
+func newPreprocessor(path string, tokens map[string][]*Token, opts *Options, observer fileObserver) (*preprocessor, error) {
+	diags := opts.Diagnostics
+	if diags == nil {
+		diags = &diag.Collector{}
+	}
+	if opts.Std != 0 && !opts.Std.isValid() {
+		diags.ReportError(diag.Fatalf(srcpos.Position{}, "invalid C standard: %d", opts.Std))
+		return nil, diags.Err()
+	}
+	maxIncludeDepth := opts.MaxIncludeDepth
+	if maxIncludeDepth == 0 {
+		maxIncludeDepth = DefaultMaxIncludeDepth
+	}
+	pragmas := opts.Pragmas
+	if pragmas == nil {
+		pragmas = NewPragmaRegistry()
+	}
+	fsys := opts.FS
+	if fsys == nil {
+		fsys = tokenMapFS(tokens)
+	}
+	p := &preprocessor{
+		path:  path,
+		files: newFileCache(fsys, tokens),
+		resolver: &includeResolver{
+			fsys:        fsys,
+			quoteDirs:   opts.QuoteDirs,
+			includeDirs: opts.IncludeDirs,
+			systemDirs:  opts.SystemDirs,
+		},
+		macros:             predefinedMacros(opts),
+		diags:              diags,
+		dirIndex:           -1,
+		pragmas:            pragmas,
+		warnUnknownPragmas: opts.WarnUnknownPragmas,
+		keepPragmas:        opts.KeepPragmas,
+		pragmaState:        &pragmaState{},
+		maxIncludeDepth:    maxIncludeDepth,
+		observer:           observer,
+		deps:               opts.Dependencies,
+		attributes:         opts.Attributes,
+		builtins:           opts.Builtins,
+	}
+	if p.deps != nil {
+		p.deps.reset(path)
+	}
+	p.files.tokenizeOptions = TokenizeOptions{
+		KeepComments: opts.KeepComments,
+		Trigraphs:    opts.Trigraphs,
+	}
+	if err := p.load(nil); err != nil {
+		diags.ReportError(err)
+		return nil, diags.Err()
+	}
+	// The command-line options are processed before the main file as the
+	// tokens of an included file are.
+	c, err := p.newCommandLine(opts)
+	if err != nil {
+		diags.ReportError(err)
+		return nil, diags.Err()
+	}
+	if c != nil {
+		p.included = c
 	}
+	return p, nil
 }
@@ -16,32 +16,128 @@ package preprocess_test
 
 import (
 	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing/fstest"
+	"time"
 
+	"github.com/hajimehoshi/goc/internal/diag"
 	. "github.com/hajimehoshi/goc/internal/preprocess"
 )
 
 func outputPreprocessedTokens(path string, srcs map[string]string) {
+	outputPreprocessedTokensWithOptions(path, srcs, nil)
+}
+
+func outputPreprocessedTokensWithOptions(path string, srcs map[string]string, opts *Options) {
 	files := map[string][]*Token{}
 	for path, src := range srcs {
 		var err error
-		files[path], err = Tokenize([]byte(src), "")
+		files[path], err = Tokenize([]byte(src), path)
 		if err != nil {
 			fmt.Println("error")
 			return
 		}
 	}
 
-	tks, err := Preprocess(path, files)
+	var tks []*Token
+	var err error
+	if opts != nil {
+		tks, err = PreprocessWithOptions(path, files, opts)
+	} else {
+		tks, err = Preprocess(path, files)
+	}
 	if err != nil {
 		fmt.Println("error")
 		return
 	}
-	
+
 	for _, t := range tks {
 		fmt.Println(t)
 	}
 }
 
+// outputPreprocessedLines prints the preprocessed tokens separated by spaces,
+// putting the tokens at the same line together.
+func outputPreprocessedLines(path string, srcs map[string]string) {
+	outputPreprocessedLinesWithOptions(path, srcs, nil)
+}
+
+func outputPreprocessedLinesWithOptions(path string, srcs map[string]string, opts *Options) {
+	files := map[string][]*Token{}
+	for path, src := range srcs {
+		var err error
+		files[path], err = Tokenize([]byte(src), path)
+		if err != nil {
+			fmt.Println("error")
+			return
+		}
+	}
+
+	var tks []*Token
+	var err error
+	if opts != nil {
+		tks, err = PreprocessWithOptions(path, files, opts)
+	} else {
+		tks, err = Preprocess(path, files)
+	}
+	if err != nil {
+		outputError(err)
+		return
+	}
+
+	line := []string{}
+	for i, t := range tks {
+		if i > 0 && t.Position().Line != tks[i-1].Position().Line {
+			fmt.Println(strings.Join(line, " "))
+			line = line[:0]
+		}
+		line = append(line, t.String())
+	}
+	if len(line) > 0 {
+		fmt.Println(strings.Join(line, " "))
+	}
+}
+
+func outputPreprocessError(path string, srcs map[string]string) {
+	outputPreprocessErrorWithOptions(path, srcs, &Options{
+		SystemDirs: []string{"."},
+	})
+}
+
+func outputPreprocessErrorWithOptions(path string, srcs map[string]string, opts *Options) {
+	files := map[string][]*Token{}
+	for path, src := range srcs {
+		var err error
+		files[path], err = Tokenize([]byte(src), path)
+		if err != nil {
+			outputError(err)
+			if files[path] == nil {
+				return
+			}
+		}
+	}
+
+	diags := &diag.Collector{}
+	o := *opts
+	o.Diagnostics = diags
+	PreprocessWithOptions(path, files, &o)
+	outputError(diag.List(diags.Diagnostics()))
+}
+
+func outputError(err error) {
+	l, ok := err.(diag.List)
+	if !ok {
+		fmt.Println(err)
+		return
+	}
+	r := &diag.Renderer{}
+	if err := r.RenderAll(os.Stdout, l); err != nil {
+		panic(err)
+	}
+}
+
 func ExampleEmpty() {
 	outputPreprocessedTokens("main.c", map[string]string{
 		"main.c": `#`,
@@ -71,6 +167,241 @@ func ExampleIncludeRecursive() {
 	// error
 }
 
+func ExampleIncludeQuoted() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#include "dir/foo.h"
+#include <bar.h>`,
+		"dir/foo.h": `#include "bar.h"`,
+		"dir/bar.h": `dir_bar`,
+		"bar.h":     `bar`,
+	})
+	// Output:
+	// dir_bar
+	// bar
+}
+
+func ExampleIncludeSearchOrder() {
+	srcs := map[string]string{
+		"main.c": `#include "foo.h"
+#include <foo.h>
+#include "bar.h"
+#include <baz.h>`,
+		"quote/foo.h":  `quote_foo`,
+		"inc/foo.h":    `inc_foo`,
+		"inc/bar.h":    `inc_bar`,
+		"sys/foo.h":    `sys_foo`,
+		"sys/bar.h":    `sys_bar`,
+		"sys/baz.h":    `sys_baz`,
+		"quote/baz.h":  `quote_baz`,
+		"unused/baz.h": `unused_baz`,
+	}
+	outputPreprocessedTokensWithOptions("main.c", srcs, &Options{
+		QuoteDirs:   []string{"quote"},
+		IncludeDirs: []string{"inc"},
+		SystemDirs:  []string{"sys"},
+	})
+	// Output:
+	// quote_foo
+	// inc_foo
+	// inc_bar
+	// sys_baz
+}
+
+func ExampleIncludeNext() {
+	outputPreprocessedTokensWithOptions("main.c", map[string]string{
+		"main.c": `#include <foo.h>`,
+		"inc1/foo.h": `inc1_foo
+#include_next <foo.h>`,
+		"inc2/foo.h": `inc2_foo
+#include_next "foo.h"`,
+		"sys/foo.h":  `sys_foo`,
+		"inc2/sys.h": `unused`,
+	}, &Options{
+		IncludeDirs: []string{"inc1", "inc2"},
+		SystemDirs:  []string{"sys"},
+	})
+	// Output:
+	// inc1_foo
+	// inc2_foo
+	// sys_foo
+}
+
+func ExampleIncludeFS() {
+	fsys := fstest.MapFS{
+		"usr/include/stdio.h": &fstest.MapFile{},
+		"src/main.c":          &fstest.MapFile{},
+	}
+	outputPreprocessedTokensWithOptions("src/main.c", map[string]string{
+		"src/main.c":          `#include <stdio.h>`,
+		"usr/include/stdio.h": `stdio`,
+	}, &Options{
+		FS:         fsys,
+		SystemDirs: []string{"usr/include"},
+	})
+	// Output:
+	// stdio
+}
+
+func ExampleIncludeNotFound() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#include "foo.h"
+#include <bar.h>`,
+		"foo.h":     `#include <bar.h>`,
+		"dir/bar.h": ``,
+	})
+	// Output:
+	// In file included from main.c:1:
+	// foo.h:1:10: fatal error: 'bar.h' file not found
+}
+
+func ExampleIncludeMacro() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define FOO foo
+#include "foo.h"
+BAR`,
+		"foo.h": `FOO
+#define BAR bar`,
+	})
+	// Output:
+	// foo
+	// bar
+}
+
+func ExamplePragmaOnce() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#include "foo.h"
+#include "foo.h"
+#include "bar.h"`,
+		"foo.h": `#pragma once
+foo`,
+		"bar.h": `#include "foo.h"
+bar`,
+	})
+	// Output:
+	// foo
+	// bar
+}
+
+func ExampleIncludeGuard() {
+	for _, guard := range []string{
+		"#ifndef FOO_H",
+		"#if !defined FOO_H",
+		"#if !defined(FOO_H)",
+	} {
+		outputPreprocessedTokens("main.c", map[string]string{
+			"main.c": `#include "foo.h"
+#include "foo.h"
+FOO_H`,
+			"foo.h": `
+// Comments and empty lines are allowed.
+` + guard + `
+#define FOO_H 1
+#include "foo.h"
+foo
+#endif
+`,
+		})
+	}
+	// Output:
+	// foo
+	// 1
+	// foo
+	// 1
+	// foo
+	// 1
+}
+
+func ExampleIncludeGuardUndefined() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#include "foo.h"
+#undef FOO_H
+#include "foo.h"`,
+		"foo.h": `#ifndef FOO_H
+#define FOO_H
+foo
+#endif`,
+	})
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#include "foo.h"
+#include "foo.h"`,
+		"foo.h": `#ifndef FOO_H
+#define FOO_H
+foo
+#else
+bar
+#endif`,
+	})
+	// Output:
+	// foo
+	// foo
+	// foo
+	// bar
+}
+
+func ExampleIncludeRepeated() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define X(name) name,
+#include "list.h"
+#undef X
+#define X(name) #name,
+#include "list.h"
+#undef X`,
+		"list.h": `X(foo)
+X(bar)`,
+	})
+	// Output:
+	// foo
+	// ,
+	// bar
+	// ,
+	// "foo"
+	// ,
+	// "bar"
+	// ,
+}
+
+func ExampleIncludeRecursiveWithCounter() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define DEPTH 0
+#include "rec.h"
+end`,
+		"rec.h": `#if DEPTH == 0
+#undef DEPTH
+#define DEPTH 1
+a
+#include "rec.h"
+#elif DEPTH == 1
+#undef DEPTH
+#define DEPTH 2
+b
+#include "rec.h"
+#endif`,
+	})
+	// Output:
+	// a
+	// b
+	// end
+}
+
+func ExampleIncludeMaxDepth() {
+	files := map[string][]*Token{}
+	for path, src := range map[string]string{
+		"main.c": `#include "foo.h"`,
+		"foo.h":  `#include "foo.h"`,
+	} {
+		files[path], _ = Tokenize([]byte(src), path)
+	}
+	_, err := PreprocessWithOptions("main.c", files, &Options{
+		MaxIncludeDepth: 3,
+	})
+	outputError(err)
+	// Output:
+	// In file included from foo.h:1:
+	//                  from foo.h:1:
+	//                  from main.c:1:
+	// foo.h:1:10: error: #include nested depth 4 exceeds maximum of 3
+}
+
 func ExampleDefineObjLike() {
 	outputPreprocessedTokens("main.c", map[string]string{
 		"main.c": `#define FOO
@@ -177,6 +508,20 @@ foo(1`,
 	// error
 }
 
+func ExampleDefineParameterError() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#define F(x
+#define G(x y) x
+#define H(1) 1
+#define I(x, ...`,
+	})
+	// Output:
+	// main.c:1:12: error: expected ')' or ',' but found end of line
+	// main.c:2:13: error: expected ')' or ',' but found 'y'
+	// main.c:3:11: error: expected identifier or '...' but found '1'
+	// main.c:4:17: error: expected ')' but found end of line
+}
+
 func ExampleDefineRescan() {
 	// 0. plus(plus(a, b), c)
 	// 1. add(c, plus(a, b))
@@ -310,3 +655,2134 @@ func ExampleHashPositionError3() {
 	// Output:
 	// error
 }
+
+func ExampleIf() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if 1
+a
+#endif
+#if 0
+b
+#endif
+#if 0
+c
+#else
+d
+#endif
+#if 0
+e
+#elif 1
+f
+#elif 1
+g
+#else
+h
+#endif`,
+	})
+	// Output:
+	// a
+	// d
+	// f
+}
+
+func ExampleIfNested() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if 0
+#if 1
+a
+#else
+b
+#endif
+#elif 1
+#if 0
+c
+#elif 2
+d
+#endif
+#endif`,
+	})
+	// Output:
+	// d
+}
+
+func ExampleIfSkippedTokens() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define FOO(x) x
+#if 0
+FOO(
+#foo
+#error error
+#endif
+a`,
+	})
+	// Output:
+	// a
+}
+
+func ExampleIfSkippedUnterminatedQuote() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if 0
+it's
+"unterminated
+#elif 1
+a
+#else
+don't
+#endif`,
+	})
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#if 1
+it's
+#define S "unterminated
+#else
+don't
+#endif`,
+	})
+	// Output:
+	// a
+	// main.c:2:3: error: missing terminating ' character
+	// main.c:3:11: error: missing terminating " character
+}
+
+func ExampleIfSkippedInvalidTokens() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if 0
+'\777'
+"\x100"
+\u0001
+a\u00d7
+#include <x
+#include "x
+#else
+a
+#endif`,
+	})
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#if 1
+'\777'
+\u0001
+#include <x
+#include "x
+#endif`,
+	})
+	// Output:
+	// a
+	// main.c:2:1: error: escape sequence out of range
+	// main.c:3:1: error: \u0001 is not a valid universal character
+	// main.c:4:10: error: unterminated header-name
+	// main.c:5:10: error: unterminated header-name
+}
+
+func ExampleIfDefined() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define FOO 0
+#define DEFINED defined
+#if defined FOO
+a
+#endif
+#if defined(FOO) && !defined BAR
+b
+#endif
+#if defined(BAR)
+c
+#endif
+#if DEFINED(FOO)
+d
+#endif`,
+	})
+	// Output:
+	// a
+	// b
+	// d
+}
+
+func ExampleIfDefinedError() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if defined(FOO
+#endif`,
+	})
+	// Output:
+	// error
+}
+
+func ExampleIfMacro() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define FOO 2
+#define BAR(x) ((x) * FOO)
+#if BAR(3) == 6
+a
+#endif
+#if UNDEFINED == 0 && int == 0
+b
+#endif`,
+	})
+	// Output:
+	// a
+	// b
+}
+
+func ExampleIfArithmetic() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if 1 + 2 * 3 == 7 && (1 + 2) * 3 == 9
+a
+#endif
+#if 7 / 2 == 3 && 7 % 2 == 1 && -7 / 2 == -3
+b
+#endif
+#if (1 << 4) == 16 && (-16 >> 2) == -4 && ~0 == -1
+c
+#endif
+#if (6 & 3) == 2 && (6 | 3) == 7 && (6 ^ 3) == 5
+d
+#endif
+#if 1 < 2 && 2 <= 2 && 3 > 2 && 3 >= 3 && 1 != 2 && !0
+e
+#endif
+#if 0x10 == 16 && 010 == 8 && 10L == 10 && 'a' == 97 && '\377' < 0
+f
+#endif`,
+	})
+	// Output:
+	// a
+	// b
+	// c
+	// d
+	// e
+	// f
+}
+
+func ExampleIfUnsigned() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if -1 > 0u
+a
+#endif
+#if -1 > 0
+b
+#endif
+#if 0xffffffffffffffff == -1 && 0xffffffffffffffff > 0
+c
+#endif
+#if (1 ? -1 : 0u) > 0
+d
+#endif`,
+	})
+	// Output:
+	// a
+	// c
+	// d
+}
+
+func ExampleIfIntegerSuffix() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if 1u && 1l && 1ll && 1ul && 1ull && 1lu && 1llu && 1uLL && 1LLu && 1Ul
+a
+#endif
+#if -1lu > 0 && -1LLU > 0 && -1l < 0
+b
+#endif`,
+	})
+	// Output:
+	// a
+	// b
+}
+
+func ExampleIfIntegerTooLarge() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#if 18446744073709551615u == 0xffffffffffffffff
+#else
+#error wrong
+#endif
+#if 99999999999999999999
+#endif
+#if 0x10000000000000000 > 0
+#endif`,
+	})
+	// Output:
+	// main.c:5:5: error: integer constant is too large for its type
+	// main.c:7:5: error: integer constant is too large for its type
+}
+
+func ExampleIfShortCircuit() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if 0 && 1 / 0
+a
+#elif 1 || 1 / 0
+b
+#endif
+#if 1 ? 2 : 1 / 0
+c
+#endif`,
+	})
+	// Output:
+	// b
+	// c
+}
+
+func ExampleIfDivisionByZero() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#if 1 / 0
+#endif`,
+	})
+	// Output:
+	// error
+}
+
+func ExampleIfMalformed() {
+	for _, src := range []string{
+		"#if\n#endif",
+		"#if 1 +\n#endif",
+		"#if (1\n#endif",
+		"#if 1 2\n#endif",
+		"#if 1 ? 2\n#endif",
+		"#if 1.0\n#endif",
+		"#if \"str\"\n#endif",
+		"#if 1 = 1\n#endif",
+	} {
+		outputPreprocessedTokens("main.c", map[string]string{
+			"main.c": src,
+		})
+	}
+	// Output:
+	// error
+	// error
+	// error
+	// error
+	// error
+	// error
+	// error
+	// error
+}
+
+func ExampleIfdef() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define FOO
+#ifdef FOO
+a
+#endif
+#ifdef BAR
+b
+#else
+c
+#endif
+#ifndef FOO
+d
+#elif 1
+e
+#endif
+#ifndef BAR
+f
+#endif`,
+	})
+	// Output:
+	// a
+	// c
+	// e
+	// f
+}
+
+func ExampleIfdefError() {
+	for _, src := range []string{
+		"#ifdef\n#endif",
+		"#ifdef 1\n#endif",
+		"#ifndef FOO BAR\n#endif",
+	} {
+		outputPreprocessedTokens("main.c", map[string]string{
+			"main.c": src,
+		})
+	}
+	// Output:
+	// error
+	// error
+	// error
+}
+
+func ExampleIfSkippedInvalidDirectives() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#ifdef FOO
+#ifdef
+#if
+#elif
+#foo bar
+#include
+#define
+#endif
+#endif
+#else
+a
+#endif`,
+	})
+	// Output:
+	// a
+}
+
+func ExampleIfMismatch() {
+	for _, src := range []string{
+		"#else",
+		"#elif 1",
+		"#endif",
+		"#if 1\n#else\n#else\n#endif",
+		"#if 0\n#else\n#elif 1\n#endif",
+		"#if 1\n#else\n#elif 1\n#endif",
+		"#if 0\n#if 1\n#else\n#else\n#endif\n#endif",
+	} {
+		outputPreprocessError("main.c", map[string]string{
+			"main.c": src,
+		})
+	}
+	// Output:
+	// main.c:1:1: error: #else without #if
+	// main.c:1:1: error: #elif without #if
+	// main.c:1:1: error: #endif without #if
+	// main.c:3:1: error: #else after #else
+	// main.c:1:1: note: the if-section began with #if here
+	// main.c:3:1: error: #elif after #else
+	// main.c:1:1: note: the if-section began with #if here
+	// main.c:3:1: error: #elif after #else
+	// main.c:1:1: note: the if-section began with #if here
+	// main.c:4:1: error: #else after #else
+	// main.c:2:1: note: the if-section began with #if here
+}
+
+func ExampleIfExtraTokens() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#if 1
+#else A
+#endif B
+#if 0
+#if 1
+#else C
+#endif D
+#else E
+#endif F
+#if 0
+#endif G`,
+	})
+	// Output:
+	// main.c:2:7: warning: extra tokens at end of #else directive
+	// main.c:3:8: warning: extra tokens at end of #endif directive
+	// main.c:8:7: warning: extra tokens at end of #else directive
+	// main.c:9:8: warning: extra tokens at end of #endif directive
+	// main.c:11:8: warning: extra tokens at end of #endif directive
+}
+
+func ExampleIfUnterminated() {
+	for _, src := range []string{
+		"#if 1\na",
+		"#ifdef FOO\na",
+		"\n#ifndef FOO\n#if 1\n#endif",
+	} {
+		outputPreprocessError("main.c", map[string]string{
+			"main.c": src,
+		})
+	}
+	// Output:
+	// main.c:1:1: error: unterminated #if
+	// main.c:1:1: error: unterminated #ifdef
+	// main.c:2:1: error: unterminated #ifndef
+}
+
+func ExampleIfAcrossInclude() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#if 1
+#include "foo.h"
+#endif`,
+		"foo.h": `#endif`,
+	})
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#include "foo.h"
+#endif`,
+		"foo.h": `#if 1`,
+	})
+	// Output:
+	// In file included from main.c:2:
+	// foo.h:1:1: error: #endif without #if
+	// In file included from main.c:1:
+	// foo.h:1:1: error: unterminated #if
+	// main.c:2:1: error: #endif without #if
+}
+
+func ExampleHashHash() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define CAT(x, y) x ## y
+#define PREFIX(name) prefix_##name
+#define OBJ foo ## bar
+#define CAT3(x, y, z) x ## y ## z
+CAT(a, b)
+PREFIX(init)
+OBJ
+CAT(1, 2)
+CAT(<, <=)
+CAT3(a, b, c)
+CAT(a b, c d)`,
+	})
+	// Output:
+	// ab
+	// prefix_init
+	// foobar
+	// 12
+	// <<=
+	// abc
+	// a
+	// bc
+	// d
+}
+
+func ExampleHashHashPlacemarker() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define CAT(x, y) x ## y
+#define CAT3(x, y, z) x ## y ## z
+[CAT(, b)]
+[CAT(a, )]
+[CAT(, )]
+[CAT3(, , c)]
+[CAT3(a, , )]
+[CAT3(, b, )]`,
+	})
+	// Output:
+	// [
+	// b
+	// ]
+	// [
+	// a
+	// ]
+	// [
+	// ]
+	// [
+	// c
+	// ]
+	// [
+	// a
+	// ]
+	// [
+	// b
+	// ]
+}
+
+func ExampleHashHashNotExpanded() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define CAT(x, y) x ## y
+#define a1 A1
+#define a b
+#define ab AB
+CAT(a, 1)
+CAT(a, b)`,
+	})
+	// Output:
+	// A1
+	// AB
+}
+
+func ExampleHashHashStringify() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define F(x, y) #x ## y
+F(a, )`,
+	})
+	// Output:
+	// "a"
+}
+
+func ExampleHashHashNotOperator() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define hash_hash # ## #
+#define F(x) x
+hash_hash
+F(a ## b)`,
+	})
+	// Output:
+	// ##
+	// a
+	// ##
+	// b
+}
+
+func ExampleHashHashError() {
+	for _, src := range []string{
+		"#define F(x) ## x",
+		"#define F(x) x ##",
+		"#define F ##",
+		"#define F(x, y) x ## y\nF(+, -)",
+		"#define F(x, y) x ## y\nF(/, /)",
+		"#define F(x, y) x ## y\nF(a, \"b\")",
+	} {
+		outputPreprocessedTokens("main.c", map[string]string{
+			"main.c": src,
+		})
+	}
+	// Output:
+	// error
+	// error
+	// error
+	// error
+	// error
+	// error
+}
+
+func ExampleVariadic() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define LOG(fmt, ...) printf(fmt, __VA_ARGS__)
+#define ALL(...) [__VA_ARGS__]
+LOG("%d %d", 1, (2, 3))
+ALL()
+ALL(a, b)`,
+	})
+	// Output:
+	// printf
+	// (
+	// "%d %d"
+	// ,
+	// 1
+	// ,
+	// (
+	// 2
+	// ,
+	// 3
+	// )
+	// )
+	// [
+	// ]
+	// [
+	// a
+	// ,
+	// b
+	// ]
+}
+
+func ExampleVariadicHash() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define STR(...) #__VA_ARGS__
+#define CAT(x, ...) x ## __VA_ARGS__
+STR()
+STR(a,b, c)
+CAT(a, b, c)
+CAT(a)`,
+	})
+	// Output:
+	// "" "a,b, c"
+	// ab
+	// ,
+	// c
+	// a
+}
+
+func ExampleVariadicVaOpt() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define F(a, ...) f(a __VA_OPT__(,) __VA_ARGS__)
+#define G(X, ...) X ## __VA_OPT__(X) __VA_OPT__(#__VA_ARGS__)
+#define H(...) #__VA_OPT__(x y)
+F(1)
+F(1, 2, 3)
+G(a)
+G(a, b)
+H()
+H(1)`,
+	})
+	// Output:
+	// f
+	// (
+	// 1
+	// )
+	// f
+	// (
+	// 1
+	// ,
+	// 2
+	// ,
+	// 3
+	// )
+	// a
+	// aa
+	// "b" "" "x y"
+}
+
+func ExampleVariadicVaOptPaste() {
+	// The examples in 6.10.5.2 of C23.
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": `#define H2(X, Y, ...) __VA_OPT__(X ## Y,) __VA_ARGS__
+#define H3(X, ...) #__VA_OPT__(X##X X##X)
+#define H4(X, ...) __VA_OPT__(a X ## X) ## b
+#define H5A(...) __VA_OPT__()/**/__VA_OPT__()
+#define H5B(X) a ## X ## b
+#define H5C(X) H5B(X)
+H2(a, b, c, d)
+H3(, 0)
+H4(, 1)
+H5C(H5A())`,
+	})
+	// Output:
+	// ab , c , d
+	// ""
+	// a b
+	// ab
+}
+
+func ExampleVariadicCommaElision() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define LOG(fmt, ...) printf(fmt, ## __VA_ARGS__)
+LOG("a")
+LOG("a", )
+LOG("a", 1, 2)`,
+	})
+	// Output:
+	// printf
+	// (
+	// "a"
+	// )
+	// printf
+	// (
+	// "a"
+	// )
+	// printf
+	// (
+	// "a"
+	// ,
+	// 1
+	// ,
+	// 2
+	// )
+}
+
+func ExampleVariadicError() {
+	for _, src := range []string{
+		"#define F(..., a) a",
+		"#define F(__VA_ARGS__) __VA_ARGS__",
+		"#define F(a) __VA_ARGS__",
+		"#define F __VA_ARGS__",
+		"#define F(a) __VA_OPT__(a)",
+		"#define F(...) __VA_OPT__",
+		"#define F(...) __VA_OPT__(",
+		"#define F(...) __VA_OPT__(__VA_OPT__())",
+		"#define F(...) __VA_OPT__(## a)",
+		"#define F(a, b, ...) a\nF(1)",
+	} {
+		outputPreprocessedTokens("main.c", map[string]string{
+			"main.c": src,
+		})
+	}
+	// Output:
+	// error
+	// error
+	// error
+	// error
+	// error
+	// error
+	// error
+	// error
+	// error
+	// error
+}
+
+func ExampleEmptyArgument() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define F(x) [x]
+#define G(x, y) [x y]
+F()
+G(,)`,
+	})
+	// Output:
+	// [
+	// ]
+	// [
+	// ]
+}
+
+func ExamplePosition() {
+	files := map[string][]*Token{}
+	for path, src := range map[string]string{
+		"main.c": `#include "foo.h"
+#define ID(x) x
+#define STR(x) #x
+#define CAT(x, y) x ## y
+#define FOO ID(bar) baz
+  a FOO
+ID(ID(b))
+STR(c) CAT(d, e)
+"f"
+  "g"`,
+		"foo.h": `h`,
+	} {
+		var err error
+		files[path], err = Tokenize([]byte(src), path)
+		if err != nil {
+			fmt.Println("error")
+			return
+		}
+	}
+
+	tks, err := Preprocess("main.c", files)
+	if err != nil {
+		fmt.Println("error")
+		return
+	}
+	for _, t := range tks {
+		fmt.Println(t, t.Pos, t.ExpansionPos, t.Position())
+	}
+	// Output:
+	// h foo.h:1:1 - foo.h:1:1
+	// a main.c:6:3 - main.c:6:3
+	// bar main.c:5:16 main.c:6:5 main.c:6:5
+	// baz main.c:5:21 main.c:6:5 main.c:6:5
+	// b main.c:7:7 main.c:7:1 main.c:7:1
+	// "c" main.c:3:16 main.c:8:1 main.c:8:1
+	// de main.c:8:12 main.c:8:8 main.c:8:8
+	// "f" "g" main.c:9:1 - main.c:9:1
+}
+
+func ExampleErrorRecovery() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#define F(x) x
+#if 1 +
+#endif
+F(1, 2)
+#foo
+#define G(x) #y
+#define H 1 / 0
+#if H
+#endif
+#ifdef
+#endif
+'ab
+#error foo
+a`,
+	})
+	// Output:
+	// main.c:2:7: error: unexpected end of preprocessor expression
+	// main.c:4:1: error: macro "F" requires 1 arguments, but 2 given
+	// main.c:5:2: error: invalid preprocessing directive #foo
+	// main.c:6:14: error: '#' is not followed by a macro parameter
+	// main.c:8:5: error: division by zero in preprocessor expression
+	// main.c:7:13: note: expanded from macro 'H'
+	// main.c:10:2: error: no macro name given in #ifdef directive
+	// main.c:12:1: error: missing terminating ' character
+	// main.c:13:1: error: #error foo
+}
+
+func ExampleErrorNestedMacro() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#define DIV(x) x / 0
+#define ONE 1
+#define OUTER DIV(ONE)
+#if OUTER
+#endif`,
+	})
+	// Output:
+	// main.c:4:5: error: division by zero in preprocessor expression
+	// main.c:3:15: note: expanded from macro 'OUTER'
+	// main.c:1:18: note: expanded from macro 'DIV'
+}
+
+type loggingFS struct {
+	fsys fs.FS
+}
+
+func (l *loggingFS) Open(name string) (fs.File, error) {
+	fmt.Println("open:", name)
+	return l.fsys.Open(name)
+}
+
+func ExamplePreprocessFS() {
+	fsys := &loggingFS{
+		fsys: fstest.MapFS{
+			"main.c": &fstest.MapFile{
+				Data: []byte(`#include "foo.h"
+#if 0
+#include "bar.h"
+#endif`),
+			},
+			"foo.h": &fstest.MapFile{
+				Data: []byte(`foo`),
+			},
+			"bar.h": &fstest.MapFile{
+				Data: []byte(`bar`),
+			},
+		},
+	}
+	tks, err := PreprocessFS(fsys, "main.c", nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, t := range tks {
+		fmt.Println(t)
+	}
+	// Output:
+	// open: main.c
+	// open: foo.h
+	// open: foo.h
+	// foo
+}
+
+func ExamplePreprocessFSError() {
+	fsys := fstest.MapFS{
+		"main.c": &fstest.MapFile{
+			Data: []byte(`#include "foo.h"`),
+		},
+		"foo.h": &fstest.MapFile{
+			Data: []byte(`"foo`),
+		},
+	}
+	_, err := PreprocessFS(fsys, "main.c", nil)
+	outputError(err)
+	_, err = PreprocessFS(fsys, "bar.c", nil)
+	outputError(err)
+	// Output:
+	// In file included from main.c:1:
+	// foo.h:1:1: error: missing terminating " character
+	// fatal error: bar.c: open bar.c: file does not exist
+}
+
+func ExamplePreprocessWithOptionsNil() {
+	tks, err := Tokenize([]byte(`#define A 1
+A __STDC__`), "main.c")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	tks, err = PreprocessWithOptions("main.c", map[string][]*Token{
+		"main.c": tks,
+	}, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, t := range tks {
+		fmt.Println(t)
+	}
+	// Output:
+	// 1
+	// 1
+}
+
+func ExamplePredefinedMacros() {
+	now := func() time.Time {
+		return time.Date(2018, time.March, 4, 5, 6, 7, 0, time.UTC)
+	}
+	for _, std := range []Standard{C89, C99, 0} {
+		outputPreprocessedTokensWithOptions("main.c", map[string]string{
+			"main.c": `__DATE__ ; __TIME__ __STDC__ __STDC_HOSTED__
+#ifdef __STDC_VERSION__
+__STDC_VERSION__
+#endif`,
+		}, &Options{
+			Std: std,
+			Now: now,
+		})
+	}
+	outputPreprocessedTokensWithOptions("main.c", map[string]string{
+		"main.c": `__STDC_HOSTED__`,
+	}, &Options{
+		Freestanding: true,
+	})
+	// Output:
+	// "Mar  4 2018"
+	// ;
+	// "05:06:07"
+	// 1
+	// 1
+	// "Mar  4 2018"
+	// ;
+	// "05:06:07"
+	// 1
+	// 1
+	// 199901L
+	// "Mar  4 2018"
+	// ;
+	// "05:06:07"
+	// 1
+	// 1
+	// 201112L
+	// 0
+}
+
+func ExamplePredefinedMacrosInvalidStandard() {
+	outputPreprocessErrorWithOptions("main.c", map[string]string{
+		"main.c": `__STDC_VERSION__`,
+	}, &Options{
+		Std: C23 + 1,
+	})
+	// Output:
+	// fatal error: invalid C standard: 7
+}
+
+func ExamplePredefinedMacrosLocation() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `__FILE__ __LINE__
+#define LOC __FILE__ __LINE__
+#define ID(x) x
+#include "dir/foo.h"
+LOC
+ID(
+__LINE__)
+#if __LINE__ == 8
+eight
+#endif`,
+		"dir/foo.h": `__FILE__ __LINE__
+LOC`,
+	})
+	// Output:
+	// "main.c"
+	// 1
+	// "dir/foo.h"
+	// 1
+	// "dir/foo.h"
+	// 2
+	// "main.c"
+	// 5
+	// 6
+	// eight
+}
+
+func ExamplePredefinedMacrosCounter() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define ID(x) x
+__COUNTER__ __COUNTER__
+ID(__COUNTER__)
+#include "foo.h"`,
+		"foo.h": `__COUNTER__`,
+	})
+	// Output:
+	// 0
+	// 1
+	// 2
+	// 3
+}
+
+func ExampleLine() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `__LINE__ __FILE__
+#line 100
+__LINE__ __FILE__
+#define NUM 200
+#define FILE "foo.y"
+#line NUM FILE
+__LINE__ \
+__FILE__
+__LINE__
+#line 010
+__LINE__
+#include "foo.h"`,
+		"foo.h": `__LINE__ __FILE__`,
+	})
+	// Output:
+	// 1
+	// "main.c"
+	// 100
+	// "main.c"
+	// 200
+	// "foo.y"
+	// 202
+	// 10
+	// 1
+	// "foo.h"
+}
+
+func ExampleLineDiagnostics() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#line 10 "foo.y"
+#include "foo.h"
+#foo`,
+		"foo.h": `#bar`,
+	})
+	// Output:
+	// In file included from foo.y:10:
+	// foo.h:1:2: error: invalid preprocessing directive #bar
+	// foo.y:11:2: error: invalid preprocessing directive #foo
+}
+
+func ExampleLineError() {
+	for _, src := range []string{
+		"#line",
+		"#line foo",
+		"#line 0x10",
+		"#line 10u",
+		"#line 2147483648",
+		"#line 1 foo",
+		"#line 1 L\"foo\"",
+		"#line 0",
+		"#line 1 \"foo\" bar",
+	} {
+		outputPreprocessError("main.c", map[string]string{
+			"main.c": src,
+		})
+	}
+	// Output:
+	// main.c:1:2: error: #line directive requires a positive integer argument
+	// main.c:1:7: error: #line directive requires a positive integer argument
+	// main.c:1:7: error: #line directive requires a positive integer argument
+	// main.c:1:7: error: #line directive requires a positive integer argument
+	// main.c:1:7: error: line number out of range
+	// main.c:1:9: error: invalid filename for #line directive
+	// main.c:1:9: error: invalid filename for #line directive
+	// main.c:1:7: warning: #line directive with zero argument
+	// main.c:1:15: warning: extra tokens at end of #line directive
+}
+
+func ExampleIncludeNotRescanned() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define BAR bar
+#include "foo.h"
+FOO`,
+		"foo.h": `FOO
+#define FOO foo
+#undef BAR
+BAR`,
+	})
+	// Output:
+	// FOO
+	// BAR
+	// foo
+}
+
+func ExamplePragma() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#pragma foo bar
+#pragma pack(push, 1)
+#pragma STDC FP_CONTRACT ON
+#pragma STDC FP_CONTRACT MAYBE
+#pragma STDC FOO
+#pragma GCC diagnostic push
+#pragma GCC diagnostic ignored "-Wfoo"
+#pragma GCC diagnostic pop
+#pragma GCC diagnostic pop
+#pragma GCC warning "foo" "bar"
+#pragma GCC error "baz"
+#pragma
+a`,
+	})
+	// Output:
+	// main.c:4:26: warning: expected 'ON' or 'OFF' or 'DEFAULT' in pragma
+	// main.c:5:2: warning: unknown pragma in STDC namespace
+	// main.c:9:24: warning: pragma diagnostic pop could not pop, no matching push
+	// main.c:10:2: warning: foobar
+	// main.c:11:2: error: baz
+}
+
+func ExamplePragmaUnknown() {
+	files := map[string][]*Token{}
+	files["main.c"], _ = Tokenize([]byte(`#pragma foo bar
+#pragma omp parallel`), "main.c")
+	diags := &diag.Collector{}
+	PreprocessWithOptions("main.c", files, &Options{
+		WarnUnknownPragmas: true,
+		Diagnostics:        diags,
+	})
+	outputError(diag.List(diags.Diagnostics()))
+	// Output:
+	// main.c:1:9: warning: unknown pragma ignored
+	// main.c:2:9: warning: unknown pragma ignored
+}
+
+func ExamplePragmaRegistry() {
+	r := NewPragmaRegistry()
+	r.Register("goc", func(pragma *Pragma) error {
+		fmt.Println("goc:", pragma.Pos, pragma.Name, pragma.Tokens)
+		return nil
+	})
+	r.Register("goc  hello", func(pragma *Pragma) error {
+		fmt.Println("goc hello:", pragma.Pos, pragma.Name, pragma.Tokens)
+		return nil
+	})
+	r.Register("pack", func(pragma *Pragma) error {
+		return fmt.Errorf("pack is not supported")
+	})
+	outputPreprocessedTokensWithOptions("main.c", map[string]string{
+		"main.c": `#pragma goc foo 1
+#pragma goc hello world
+#pragma goc
+#pragma gocfoo`,
+	}, &Options{
+		Pragmas: r,
+	})
+	outputPreprocessedTokensWithOptions("main.c", map[string]string{
+		"main.c": `#pragma pack(1)`,
+	}, &Options{
+		Pragmas: r,
+	})
+	// Output:
+	// goc: main.c:1:2 goc [foo 1]
+	// goc hello: main.c:2:2 goc hello [world]
+	// goc: main.c:3:2 goc []
+	// error
+}
+
+func ExamplePragmaOperator() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#define DO_PRAGMA(x) _Pragma(#x)
+#define WARN(msg) DO_PRAGMA(GCC warning msg)
+_Pragma("GCC warning \"foo\"") a
+WARN("bar") b
+_Pragma("GCC warning \"\\\\baz\"")
+_Pragma
+("GCC warning \"qux\"")
+_Pragma c
+_Pragma("STDC FP_CONTRACT ON")`,
+	})
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#include "foo.h"
+#include "foo.h"
+#define ONCE _Pragma("once")
+#include "bar.h"
+#include "bar.h"`,
+		"foo.h": `_Pragma("once") foo`,
+		"bar.h": `ONCE bar`,
+	})
+	// Output:
+	// main.c:3:1: warning: foo
+	// main.c:4:1: warning: bar
+	// main.c:5:1: warning: \baz
+	// main.c:6:1: warning: qux
+	// main.c:8:1: error: _Pragma takes a parenthesized string literal
+	// foo
+	// bar
+}
+
+func ExampleRescanHideSet() {
+	// "6.10.3.5 Scope of macro definitions" EXAMPLE 3 [spec]
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": `#define x 3
+#define f(a) f(x * (a))
+#undef x
+#define x 2
+#define g f
+#define z z[0]
+#define h g(~
+#define m(a) a(w)
+#define w 0,1
+#define t(a) a
+#define p() int
+#define q(x) x
+#define r(x,y) x ## y
+#define str(x) # x
+f(y+1) + f(f(z)) % t(t(g)(0) + t)(1);
+g(x+(3,4)-w) | h 5) & m
+(f)^m(m);
+p() i[q()] = { q(1), r(2,3), r(4,), r(,5), r(,) };
+char c[2][6] = { str(hello), str() };`,
+	})
+	// Output:
+	// f ( 2 * ( y + 1 ) ) + f ( 2 * ( f ( 2 * ( z [ 0 ] ) ) ) ) % f ( 2 * ( 0 ) ) + t ( 1 ) ;
+	// f ( 2 * ( 2 + ( 3 , 4 ) - 0 , 1 ) ) | f ( 2 * ( ~ 5 ) ) & f ( 2 * ( 0 , 1 ) )
+	// ^ m ( 0 , 1 ) ;
+	// int i [ ] = { 1 , 23 , 4 , 5 , } ;
+	// char c [ 2 ] [ 6 ] = { "hello" , "" } ;
+}
+
+func ExampleRescanStringify() {
+	// "6.10.3.5 Scope of macro definitions" EXAMPLE 4 [spec]
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": `#define str(s) # s
+#define xstr(s) str(s)
+#define debug(s, t) printf("x" # s "= %d, x" # t "= %s", \
+x ## s, x ## t)
+#define INCFILE(n) vers ## n
+#define glue(a, b) a ## b
+#define xglue(a, b) glue(a, b)
+#define HIGHLOW "hello"
+#define LOW LOW ", world"
+debug(1, 2);
+fputs(str(strncmp("abc\0d", "abc", '\4') // this goes away
+== 0) str(: @\n), s);
+xstr(INCFILE(2).h);
+glue(HIGH, LOW);
+xglue(HIGH, LOW)`,
+	})
+	// Output:
+	// printf ( "x" "1" "= %d, x" "2" "= %s" , x1 , x2 ) ;
+	// fputs ( "strncmp(\"abc\\0d\", \"abc\", '\\4') == 0" ": @\n"
+	// , s ) ;
+	// "vers2.h" ;
+	// "hello" ;
+	// "hello" ", world"
+}
+
+func ExampleRescanPlacemarker() {
+	// "6.10.3.5 Scope of macro definitions" EXAMPLE 5 [spec]
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": `#define t(x,y,z) x ## y ## z
+int j[] = { t(1,2,3), t(,4,5), t(6,,7), t(8,9,),
+t(10,,), t(,11,), t(,,12), t(,,) };`,
+	})
+	// Output:
+	// int j [ ] = { 123 , 45 , 67 , 89 ,
+	// 10 , 11 , 12 , } ;
+}
+
+func ExampleRescanVariadic() {
+	// "6.10.3.5 Scope of macro definitions" EXAMPLE 7 [spec]
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": `#define debug(...) fprintf(stderr, __VA_ARGS__)
+#define showlist(...) puts(#__VA_ARGS__)
+#define report(test, ...) ((test)?puts(#test):\
+printf(__VA_ARGS__))
+debug("Flag");
+debug("X = %d\n", x);
+showlist(The first, second, and third items.);
+report(x>y, "x is %d but y is %d", x, y);`,
+	})
+	// Output:
+	// fprintf ( stderr , "Flag" ) ;
+	// fprintf ( stderr , "X = %d\n" , x ) ;
+	// puts ( "The first, second, and third items." ) ;
+	// ( ( x > y ) ? puts ( "x>y" ) : printf ( "x is %d but y is %d" , x , y ) ) ;
+}
+
+func ExampleRescanArgumentsAfterExpansion() {
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": `#define f(a) a*g
+#define g(a) f(a)
+#define h() g
+#define i h()(1)
+#define ID(x) x
+f(2)(9);
+h()(1) i;
+f(f)(1);
+ID(f)(1);
+ID(ID)(1);
+f
+(3);
+f;`,
+	})
+	// Output:
+	// 2 * 9 * g ;
+	// 1 * g 1 * g ;
+	// f * 1 * g ;
+	// 1 * g ;
+	// ID ( 1 ) ;
+	// 3 * g
+	// ;
+	// f ;
+}
+
+func ExampleRescanPreExpansion() {
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": `#define CAT(a, b) a ## b
+#define CAT2(a, b) CAT(a, b)
+#define ONE 1
+#define F(x) [x]
+#define G F
+CAT(ONE, ONE) CAT2(ONE, ONE);
+CAT2(a, __COUNTER__) CAT2(a, __COUNTER__);
+F(G(1));
+F(F(1));`,
+	})
+	// Output:
+	// ONEONE 11 ;
+	// a0 a1 ;
+	// [ [ 1 ] ] ;
+	// [ [ 1 ] ] ;
+}
+
+func ExampleDefineRedefinition() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#define OBJ (1 + 2)
+#define OBJ   (1   +   2)  
+#define OBJ (1+2)
+#define F(x, y) x ## y
+#define F(x, y) x ## y
+#define F(a, b) a ## b
+#define G(...) __VA_ARGS__
+#define G(x) x
+#define __STDC__ 1
+#define __LINE__ 1
+OBJ`,
+	})
+	// Output:
+	// main.c:3:9: warning: macro "OBJ" redefined
+	// main.c:2:9: note: previous definition is here
+	// main.c:6:9: warning: macro "F" redefined
+	// main.c:5:9: note: previous definition is here
+	// main.c:8:9: warning: macro "G" redefined
+	// main.c:7:9: note: previous definition is here
+	// main.c:10:9: warning: macro "__LINE__" redefined
+	// <built-in>: note: previous definition is here
+}
+
+func ExampleDefineRedefinitionReplacesMacro() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define A 1
+#define A 2
+A`,
+	})
+	// Output:
+	// 2
+}
+
+func ExampleMacroTable() {
+	files := map[string][]*Token{}
+	for path, src := range map[string]string{
+		"main.c": `#include "config.h"
+#define VERSION "1.0"
+#define MAX(a, b) ((a) > (b) ? (a) : (b))
+#define LOG(fmt, ...) printf(fmt, __VA_ARGS__)
+#define EMPTY
+#define UNDEFINED 1
+#undef UNDEFINED`,
+		"config.h": `#define HAVE_FOO 1`,
+	} {
+		var err error
+		files[path], err = Tokenize([]byte(src), path)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	table := &MacroTable{}
+	if _, err := PreprocessWithOptions("main.c", files, &Options{
+		Now:    func() time.Time { return time.Date(2018, time.January, 2, 3, 4, 5, 0, time.UTC) },
+		Macros: table,
+	}); err != nil {
+		panic(err)
+	}
+	for _, m := range table.Macros() {
+		fmt.Printf("%s: %s\n", m.Pos, m)
+	}
+	if _, ok := table.Lookup("UNDEFINED"); !ok {
+		fmt.Println("UNDEFINED is not defined")
+	}
+	m, _ := table.Lookup("LOG")
+	fmt.Println(m.IsFunctionLike(), m.Params, m.Variadic, len(m.Replacement))
+	// Output:
+	// main.c:5:9: #define EMPTY
+	// config.h:1:9: #define HAVE_FOO 1
+	// main.c:4:9: #define LOG(fmt,...) printf(fmt, __VA_ARGS__)
+	// main.c:3:9: #define MAX(a,b) ((a) > (b) ? (a) : (b))
+	// main.c:2:9: #define VERSION "1.0"
+	// <built-in>: #define __DATE__ "Jan  2 2018"
+	// <built-in>: #define __STDC_HOSTED__ 1
+	// <built-in>: #define __STDC_VERSION__ 201112L
+	// <built-in>: #define __STDC__ 1
+	// <built-in>: #define __TIME__ "03:04:05"
+	// UNDEFINED is not defined
+	// true [fmt] true 6
+}
+
+func ExampleCommandLine() {
+	outputPreprocessedLinesWithOptions("main.c", map[string]string{
+		"main.c": `FOO BAR BAZ QUX F(2) __STDC_HOSTED__ PRELUDE CONFIG
+#ifdef DEBUG
+debug
+#endif`,
+		"prelude.h": `#define PRELUDE prelude FOO
+prelude_h`,
+		"include/config.h": `#define CONFIG config`,
+	}, &Options{
+		IncludeDirs: []string{"include"},
+		MacroOptions: []MacroOption{
+			Define("FOO"),
+			Define("BAR=bar"),
+			Define("BAZ="),
+			Define("F(x)=(x + 1)"),
+			Define("DEBUG"),
+			Undefine("DEBUG"),
+			Undefine("__STDC_HOSTED__"),
+			Define("QUX=1"),
+			Undefine("QUX"),
+			Define("QUX=2"),
+		},
+		ForceIncludes: []string{"prelude.h", "config.h"},
+	})
+	// Output:
+	// prelude_h
+	// 1 bar 2 ( 2 + 1 ) __STDC_HOSTED__ prelude 1 config
+}
+
+func ExampleCommandLineError() {
+	outputPreprocessErrorWithOptions("main.c", map[string]string{
+		"main.c":    `FOO`,
+		"prelude.h": `#error in prelude`,
+	}, &Options{
+		MacroOptions: []MacroOption{
+			Define("FOO=1"),
+			Define("FOO=2"),
+			Define("F(x=1"),
+			Undefine("1"),
+		},
+		ForceIncludes: []string{"prelude.h", "missing.h"},
+	})
+	// Output:
+	// <command line>:2:9: warning: macro "FOO" redefined
+	// <command line>:1:9: note: previous definition is here
+	// <command line>:3:13: error: expected ')' or ',' but found '1'
+	// <command line>:4:8: error: expected identifier but found '1'
+	// In file included from <command line>:5:
+	// prelude.h:1:1: error: #error in prelude
+	// <command line>:6:10: fatal error: 'missing.h' file not found
+}
+
+func ExampleCommandLineInvalid() {
+	outputPreprocessErrorWithOptions("main.c", map[string]string{
+		"main.c": `#if defined B || D != 5
+#error B
+#endif`,
+	}, &Options{
+		MacroOptions: []MacroOption{
+			Define("A=1\n#define B 2"),
+			Define("B=3 \\"),
+			Define("C=/* 4"),
+			Define("D=5"),
+		},
+		ForceIncludes: []string{`foo.h" bar`},
+	})
+	// Output:
+	// <command line>:1: error: new-line in macro option "A=1\n#define B 2"
+	// <command line>:2: error: backslash at end of macro option "B=3 \\"
+	// <command line>:3:11: error: unterminated comment
+	// <command line>:5: error: invalid character in forced include "foo.h\" bar"
+}
+
+func outputText(path string, srcs map[string]string, opts *Options, textOpts *TextOptions) {
+	files := map[string][]*Token{}
+	for path, src := range srcs {
+		var err error
+		if opts != nil && opts.KeepComments {
+			files[path], err = TokenizeWithComments([]byte(src), path)
+		} else {
+			files[path], err = Tokenize([]byte(src), path)
+		}
+		if err != nil {
+			fmt.Println("error")
+			return
+		}
+	}
+	// Each line is prefixed with '|' so that blank lines are visible.
+	var b strings.Builder
+	err := WriteText(&b, path, files, opts, textOpts)
+	for _, l := range strings.SplitAfter(b.String(), "\n") {
+		if l != "" {
+			fmt.Print("|" + l)
+		}
+	}
+	if err != nil {
+		outputError(err)
+	}
+}
+
+func ExampleWriteText() {
+	outputText("main.c", map[string]string{
+		"main.c": `#include "foo.h"
+#define PLUS +
+#define F(x) x
+int main() {
+    int a = 1 PLUS+a, b = -F(-1);
+    return F(a)F(b);
+}
+#include <stdio.h>
+
+
+
+x
+
+
+
+
+
+
+
+
+
+
+y`,
+		"foo.h": `#define FOO 1
+int foo = FOO;`,
+		"include/stdio.h": `int printf(const char*, ...);`,
+	}, &Options{
+		SystemDirs: []string{"include"},
+	}, nil)
+	// Output:
+	// |# 1 "main.c"
+	// |# 1 "foo.h" 1
+	// |
+	// |int foo = 1;
+	// |# 2 "main.c" 2
+	// |
+	// |
+	// |int main() {
+	// |    int a = 1 + +a, b = - -1;
+	// |    return a b;
+	// |}
+	// |# 1 "include/stdio.h" 1 3
+	// |int printf(const char*, ...);
+	// |# 9 "main.c" 2
+	// |
+	// |
+	// |
+	// |x
+	// |# 23 "main.c"
+	// |y
+}
+
+func ExampleWriteText_keepComments() {
+	outputText("main.c", map[string]string{
+		"main.c": `/* header */
+#define X 1 /* in directive */
+X /* after X */ + 2 // line
+#if 0
+// skipped
+#endif
+/* multi
+   line */ end`,
+	}, &Options{
+		KeepComments: true,
+	}, nil)
+	// Output:
+	// |# 1 "main.c"
+	// |/* header */
+	// |
+	// |1 /* after X */ + 2 // line
+	// |
+	// |
+	// |
+	// |/* multi
+	// |   line */ end
+}
+
+func ExampleWriteText_noLineMarkers() {
+	outputText("main.c", map[string]string{
+		"main.c": `#include "foo.h"
+a
+
+
+b`,
+		"foo.h": `foo`,
+	}, &Options{}, &TextOptions{
+		NoLineMarkers: true,
+	})
+	// Output:
+	// |foo
+	// |a
+	// |b
+}
+
+func ExampleWriteText_nilOptions() {
+	outputText("main.c", map[string]string{
+		"main.c": `#define A 1
+A`,
+	}, nil, nil)
+	// Output:
+	// |# 1 "main.c"
+	// |
+	// |1
+}
+
+func ExampleWriteText_pragmas() {
+	outputText("main.c", map[string]string{
+		"main.c": `#include "foo.h"
+#pragma pack(push, 1)
+#pragma STDC FP_CONTRACT ON
+#define DO_PRAGMA(x) _Pragma(#x)
+a DO_PRAGMA(omp parallel for) b
+#pragma GCC diagnostic ignored "-Wfoo"
+c`,
+		"foo.h": `#pragma once
+foo`,
+	}, &Options{}, nil)
+	// Output:
+	// |# 1 "main.c"
+	// |# 1 "foo.h" 1
+	// |
+	// |foo
+	// |# 2 "main.c" 2
+	// |#pragma pack(push, 1)
+	// |#pragma STDC FP_CONTRACT ON
+	// |
+	// |a
+	// |# 5 "main.c"
+	// |#pragma omp parallel for
+	// |# 5 "main.c"
+	// |                              b
+	// |#pragma GCC diagnostic ignored "-Wfoo"
+	// |c
+}
+
+func ExamplePragmaKeep() {
+	outputPreprocessedTokensWithOptions("main.c", map[string]string{
+		"main.c": `#pragma once
+#pragma pack(1)
+_Pragma("foo  bar(x)") a
+#pragma`,
+	}, &Options{
+		KeepPragmas: true,
+	})
+	// Output:
+	// #pragma pack(1)
+	// #pragma foo bar(x)
+	// a
+	// #pragma
+}
+
+func ExamplePragmaRegistryReused() {
+	// The state of '#pragma GCC diagnostic' is not carried over to the next
+	// run.
+	r := NewPragmaRegistry()
+	for _, src := range []string{
+		`#pragma GCC diagnostic push`,
+		`#pragma GCC diagnostic pop`,
+	} {
+		outputPreprocessErrorWithOptions("main.c", map[string]string{
+			"main.c": src,
+		}, &Options{
+			Pragmas: r,
+		})
+	}
+	// Output:
+	// main.c:1:24: warning: pragma diagnostic pop could not pop, no matching push
+}
+
+func ExampleDependencies() {
+	files := map[string][]*Token{}
+	for path, src := range map[string]string{
+		"src/main.c": `#include "foo.h"
+#include "my header.h"
+#include <stdio.h>
+#include "foo.h"`,
+		"src/foo.h": `#pragma once
+#include <stddef.h>`,
+		"src/my header.h": ``,
+		"include/stdio.h": `#include <stddef.h>`,
+		"include/stddef.h": `#ifndef STDDEF_H
+#define STDDEF_H
+#endif`,
+		"include/very/long/path/to/the/system/header/file.h": ``,
+	} {
+		var err error
+		files[path], err = Tokenize([]byte(src), path)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	deps := &Dependencies{}
+	if _, err := PreprocessWithOptions("src/main.c", files, &Options{
+		SystemDirs:    []string{"include"},
+		ForceIncludes: []string{"include/very/long/path/to/the/system/header/file.h"},
+		Dependencies:  deps,
+	}); err != nil {
+		panic(err)
+	}
+	if err := deps.WriteMakefile(os.Stdout, nil); err != nil {
+		panic(err)
+	}
+	fmt.Println("--")
+	if err := deps.WriteMakefile(os.Stdout, &MakeOptions{
+		Targets:         []string{"out/main.o", "out/main.d"},
+		NoSystemHeaders: true,
+		PhonyTargets:    true,
+	}); err != nil {
+		panic(err)
+	}
+	fmt.Println("--")
+	if err := deps.WriteJSON(os.Stdout); err != nil {
+		panic(err)
+	}
+	// Output:
+	// main.o: src/main.c include/very/long/path/to/the/system/header/file.h \
+	//   src/foo.h include/stddef.h src/my\ header.h include/stdio.h
+	// --
+	// out/main.o out/main.d: src/main.c \
+	//   include/very/long/path/to/the/system/header/file.h src/foo.h \
+	//   src/my\ header.h
+	//
+	// include/very/long/path/to/the/system/header/file.h:
+	//
+	// src/foo.h:
+	//
+	// src/my\ header.h:
+	// --
+	// {"main":"src/main.c","headers":[{"path":"include/very/long/path/to/the/system/header/file.h","system":false},{"path":"src/foo.h","system":false},{"path":"include/stddef.h","system":true},{"path":"src/my header.h","system":false},{"path":"include/stdio.h","system":true}]}
+}
+
+func ExampleIncludeComputed() {
+	outputPreprocessedTokens("main.c", map[string]string{
+		"main.c": `#define HEADER "foo.h"
+#include HEADER
+#define SYS <sys/types.h>
+#include SYS
+#define str(s) # s
+#define xstr(s) str(s)
+#define INCFILE(n) vers ## n
+#include xstr(INCFILE(2).h)
+#define ANGLE(name) <name.h>
+#include ANGLE(bar)`,
+		"foo.h":       `foo`,
+		"sys/types.h": `types`,
+		"vers2.h":     `vers2`,
+		"bar.h":       `bar`,
+	})
+	// Output:
+	// foo
+	// types
+	// vers2
+	// bar
+}
+
+func ExampleIncludeComputedError() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#define EMPTY
+#include EMPTY
+#define NUM 1
+#include NUM
+#define OPEN <foo.h
+#include OPEN
+#include "foo.h" extra
+#define WIDE L"foo.h"
+#include WIDE
+#include ""`,
+		"foo.h": ``,
+	})
+	// Output:
+	// main.c:2:2: error: #include expects "FILENAME" or <FILENAME>
+	// main.c:4:10: error: #include expects "FILENAME" or <FILENAME>
+	// main.c:3:13: note: expanded from macro 'NUM'
+	// main.c:6:10: error: missing terminating > character
+	// main.c:5:14: note: expanded from macro 'OPEN'
+	// main.c:7:18: error: extra tokens at end of #include directive
+	// main.c:9:10: error: #include expects "FILENAME" or <FILENAME>
+	// main.c:8:14: note: expanded from macro 'WIDE'
+	// main.c:10:10: error: empty filename in #include
+}
+
+func ExampleHasInclude() {
+	outputPreprocessedTokensWithOptions("main.c", map[string]string{
+		"main.c": `#if __has_include(<stdio.h>)
+has_stdio
+#endif
+#if __has_include("foo.h") && !__has_include("missing.h")
+has_foo
+#endif
+#define THREADS <threads.h>
+#if __has_include(THREADS)
+has_threads
+#else
+no_threads
+#endif
+#if defined(__has_include) && defined __has_include_next
+has_operators
+#endif
+#ifdef __has_include
+ifdef
+#endif
+#include <stdio.h>`,
+		"foo.h": ``,
+		"include/stdio.h": `#if __has_include_next(<stdio.h>)
+#include_next <stdio.h>
+#endif`,
+		"sys/stdio.h": `sys_stdio`,
+	}, &Options{
+		IncludeDirs: []string{"include"},
+		SystemDirs:  []string{"sys"},
+	})
+	// Output:
+	// has_stdio
+	// has_foo
+	// no_threads
+	// has_operators
+	// ifdef
+	// sys_stdio
+}
+
+func ExampleHasAttribute() {
+	outputPreprocessedTokensWithOptions("main.c", map[string]string{
+		"main.c": `#if __has_attribute(deprecated)
+deprecated
+#endif
+#if __has_attribute(__noreturn__) == 202311
+noreturn
+#endif
+#if __has_attribute(gnu::packed)
+packed
+#endif
+#if !__has_attribute(unknown)
+unknown
+#endif
+#if __has_builtin(__builtin_expect) && !__has_builtin(__builtin_unknown)
+expect
+#endif`,
+	}, &Options{
+		Attributes: map[string]int{
+			"deprecated":  201904,
+			"noreturn":    202311,
+			"gnu::packed": 1,
+		},
+		Builtins: map[string]bool{
+			"__builtin_expect": true,
+		},
+	})
+	// Output:
+	// deprecated
+	// noreturn
+	// packed
+	// unknown
+	// expect
+}
+
+func ExampleHasIncludeError() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#if __has_include
+#endif
+#if __has_include(<stdio.h>
+#endif
+#if __has_include(1)
+#endif
+#if __has_include("a.h" "b.h")
+#endif
+#if __has_builtin()
+#endif`,
+	})
+	// Output:
+	// main.c:1:5: error: missing '(' after "__has_include"
+	// main.c:3:5: error: missing ')' after "__has_include"
+	// main.c:5:19: error: __has_include expects "FILENAME" or <FILENAME>
+	// main.c:7:25: error: extra tokens in "__has_include"
+	// main.c:9:5: error: operator "__has_builtin" requires an identifier
+}
+
+func ExampleWarning() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#warning don't   use   "this"
+foo
+#error  a+b /* comment */ 'c
+#if 0
+#error skipped
+#endif`,
+	})
+	// Output:
+	// main.c:1:1: warning: #warning don't use "this"
+	// main.c:3:1: error: #error a+b 'c
+}
+
+func ExampleEmbed() {
+	fsys := fstest.MapFS{
+		"main.c": &fstest.MapFile{
+			Data: []byte(`#define FILE "data.bin"
+const char a[] = {
+#embed FILE
+};
+const char b[] = {
+#embed <data.bin> limit(1 + 1) prefix(0x10, ) suffix(, 0x20)
+};
+const char c[] = {
+#embed "empty.bin" prefix(1,) if_empty(0)
+};
+const char d[] = {
+#embed "data.bin" __limit__(0) suffix(, 0) if_empty(-1)
+};`),
+		},
+		"data.bin": &fstest.MapFile{
+			Data: []byte("AB\xff"),
+		},
+		"empty.bin": &fstest.MapFile{},
+	}
+	tks, err := PreprocessFS(fsys, "main.c", &Options{
+		SystemDirs: []string{"."},
+	})
+	if err != nil {
+		outputError(err)
+		return
+	}
+	line := []string{}
+	for i, t := range tks {
+		if i > 0 && t.Position().Line != tks[i-1].Position().Line {
+			fmt.Println(strings.Join(line, " "))
+			line = line[:0]
+		}
+		line = append(line, t.String())
+	}
+	fmt.Println(strings.Join(line, " "))
+	// Output:
+	// const char a [ ] = {
+	// 65 , 66 , 255
+	// } ;
+	// const char b [ ] = {
+	// 0x10 , 65 , 66 , 0x20
+	// } ;
+	// const char c [ ] = {
+	// 0
+	// } ;
+	// const char d [ ] = {
+	// - 1
+	// } ;
+}
+
+func ExampleEmbedError() {
+	fsys := fstest.MapFS{
+		"main.c": &fstest.MapFile{
+			Data: []byte(`#embed
+#embed "data.bin" unknown(1)
+#embed "data.bin" limit(1) limit(2)
+#embed "data.bin" limit(-1)
+#embed "data.bin" prefix
+#embed "data.bin" gnu::offset(1)
+#embed "none.bin"
+`),
+		},
+		"data.bin": &fstest.MapFile{
+			Data: []byte("A"),
+		},
+	}
+	diags := &diag.Collector{}
+	PreprocessFS(fsys, "main.c", &Options{
+		Diagnostics: diags,
+	})
+	outputError(diag.List(diags.Diagnostics()))
+	// Output:
+	// main.c:1:2: error: #embed expects "FILENAME" or <FILENAME>
+	// main.c:2:19: error: unknown embed parameter 'unknown'
+	// main.c:3:28: error: duplicate embed parameter 'limit'
+	// main.c:4:25: error: embed parameter 'limit' must not be negative
+	// main.c:5:19: error: embed parameter 'prefix' requires a parenthesized argument
+	// main.c:6:19: error: unknown embed parameter 'gnu::offset'
+	// main.c:7:8: fatal error: 'none.bin' file not found
+}
+
+func ExampleDigraph() {
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": `%:define str(x) %:x
+%:define cat(x, y) x %:%: y
+str(<: a :> <%%>)
+cat(<, :) cat(%:, %:)
+%:if 1
+taken
+%:endif`,
+	})
+	// Output:
+	// "<: a :> <%%>"
+	// <: %:%:
+	// taken
+}
+
+func ExampleTrigraph() {
+	fsys := fstest.MapFS{
+		"main.c": &fstest.MapFile{
+			Data: []byte(`??=define A 1
+A
+// ??/
+ok
+`),
+		},
+	}
+	for _, trigraphs := range []bool{false, true} {
+		diags := &diag.Collector{}
+		tks, err := PreprocessFS(fsys, "main.c", &Options{
+			Diagnostics: diags,
+			Trigraphs:   trigraphs,
+		})
+		if err != nil {
+			outputError(err)
+			continue
+		}
+		outputError(diag.List(diags.Diagnostics()))
+		line := []string{}
+		for _, t := range tks {
+			line = append(line, t.String())
+		}
+		fmt.Println(strings.Join(line, " "))
+	}
+	// Output:
+	// main.c:1:1: warning: trigraph ??= ignored
+	// main.c:3:4: warning: trigraph ??/ ignored
+	// ? ? = define A 1 A ok
+	// 1
+}
+
+func ExampleStringConcatenation() {
+	files := map[string][]*Token{}
+	files["main.c"], _ = Tokenize([]byte(`"a" "é";
+"a" L"é" "\xff";
+u8"a" "b";
+#define S(x) u ## #x "c"
+S(é);
+#if L'\xffffffff' < 0 && u'\xffff' > 0 && '\xff' < 0
+ok
+#endif`), "main.c")
+	tks, err := PreprocessWithOptions("main.c", files, &Options{})
+	if err != nil {
+		outputError(err)
+		return
+	}
+	for _, t := range tks {
+		fmt.Printf("%s %q %x\n", t.Raw, t.Prefix, t.Units)
+	}
+	// Output:
+	// "a" "é" "" [61 c3 a9]
+	// ; "" []
+	// "a" L"é" "\xff" "L" [61 e9 ff]
+	// ; "" []
+	// u8"a" "b" "u8" [61 62]
+	// ; "" []
+	// u"é" "c" "u" [e9 63]
+	// ; "" []
+	// ok "" []
+}
+
+func ExampleStringConcatenationError() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `u"a" U"b";
+u8"a" L"b";
+L"" "a" u"c";`,
+	})
+	// Output:
+	// main.c:1:6: error: unsupported non-standard concatenation of string literals
+	// main.c:2:7: error: unsupported non-standard concatenation of string literals
+	// main.c:3:9: error: unsupported non-standard concatenation of string literals
+}
+
+func ExampleIdentifierNormalization() {
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": "#define caf\u00e9 1\n" +
+			"#define str(x) #x\n" +
+			"caf\u00e9 cafe\u0301 caf\\u00e9 cafe\\u0301\n" +
+			"str(caf\\u00e9)\n" +
+			"#undef cafe\\u0301\n" +
+			"#ifndef caf\u00e9\n" +
+			"undefined\n" +
+			"#endif",
+	})
+	// Output:
+	// 1 1 1 1
+	// "caf\u00e9"
+	// undefined
+}
+
+func ExampleIfCharacterConstant() {
+	outputPreprocessedLines("main.c", map[string]string{
+		"main.c": `#if 'a' == 97 && '\377' == -1 && '\x80' < 0
+a
+#endif
+#if 'ab' == 0x6162 && '\xff\x80' == 0xff80
+b
+#endif
+#if 'abcde' == 'bcde' && '\xff\0\0\0' < 0
+c
+#endif
+#if 'é' == 0xc3a9
+d
+#endif
+#if L'ab' == 'b' && L'\xffffffff' == -1 && L'é' == 0xe9
+e
+#endif
+#if u'\xffff' > 0 && U'\xffffffff' > 0 && u8'\xff' == 255 && u8'a' == 'a'
+f
+#endif`,
+	})
+	// Output:
+	// a
+	// b
+	// c
+	// d
+	// e
+	// f
+}
+
+func ExampleIfCharacterConstantWarning() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `#if 'ab'
+#endif
+#if 0 && 'abcde'
+#endif
+#if L'ab' || 'a'
+#endif`,
+	})
+	// Output:
+	// main.c:1:5: warning: multi-character character constant
+	// main.c:3:10: warning: character constant too long for its type
+	// main.c:5:5: warning: character constant too long for its type
+}
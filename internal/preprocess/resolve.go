@@ -0,0 +1,190 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// includeResolver resolves header names of #include directives to file paths
+// in a file system.
+//
+// "6.10.2 Source file inclusion" [spec]
+//
+// The search order follows GCC:
+//
+//   - A quoted header name is searched in the directory of the including file
+//     first, then in the quote directories (-iquote).
+//   - Both forms are then searched in the include directories (-I) and the
+//     system directories in order.
+type includeResolver struct {
+	fsys fs.FS
+
+	quoteDirs   []string
+	includeDirs []string
+	systemDirs  []string
+}
+
+// dirs returns the search directories in order: the quote directories, the
+// include directories and then the system directories.
+func (r *includeResolver) dirs() []string {
+	dirs := make([]string, 0, len(r.quoteDirs)+len(r.includeDirs)+len(r.systemDirs))
+	dirs = append(dirs, r.quoteDirs...)
+	dirs = append(dirs, r.includeDirs...)
+	dirs = append(dirs, r.systemDirs...)
+	return dirs
+}
+
+// isSystemDir reports whether the search directory at index is a system
+// directory.
+func (r *includeResolver) isSystemDir(index int) bool {
+	return index >= len(r.quoteDirs)+len(r.includeDirs)
+}
+
+// exists reports whether a regular file exists at name.
+func (r *includeResolver) exists(name string) bool {
+	if !fs.ValidPath(name) {
+		return false
+	}
+	fi, err := fs.Stat(r.fsys, name)
+	if err != nil {
+		return false
+	}
+	return !fi.IsDir()
+}
+
+// resolve resolves the header name.
+//
+// includer is the path of the including file. quoted indicates whether the
+// header name is in the form of "q-char-sequence".
+//
+// If next is true, the header name is resolved for #include_next: the search
+// starts from the directory after the search directory at dirIndex, where the
+// including file was found. dirIndex is -1 when the including file was not
+// found in the search directories.
+//
+// resolve returns the resolved path and the index of the search directory
+// where the file was found, or -1 if the file was found in the directory of
+// the including file.
+func (r *includeResolver) resolve(name string, quoted bool, includer string, next bool, dirIndex int) (string, int, bool) {
+	if path.IsAbs(name) {
+		return "", 0, false
+	}
+
+	// #include_next does not search the directory of the including file, as
+	// GCC does.
+	if quoted && !next {
+		if p := path.Join(path.Dir(includer), name); r.exists(p) {
+			return p, -1, true
+		}
+	}
+
+	start := 0
+	if !quoted {
+		start = len(r.quoteDirs)
+	}
+	if next && dirIndex+1 > start {
+		start = dirIndex + 1
+	}
+
+	dirs := r.dirs()
+	for i := start; i < len(dirs); i++ {
+		if p := path.Join(dirs[i], name); r.exists(p) {
+			return p, i, true
+		}
+	}
+	return "", 0, false
+}
+
+// tokenMapFS is a file system consisting of the paths of pre-tokenized files.
+// The contents of the files are empty.
+type tokenMapFS map[string][]*Token
+
+func (m tokenMapFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := m[name]; ok {
+		return &tokenMapFile{
+			name: path.Base(name),
+		}, nil
+	}
+
+	// Directories are not listed.
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			return &tokenMapFile{
+				name: path.Base(name),
+				dir:  true,
+			}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+type tokenMapFile struct {
+	name string
+	dir  bool
+}
+
+func (f *tokenMapFile) Stat() (fs.FileInfo, error) {
+	return f, nil
+}
+
+func (f *tokenMapFile) Read(b []byte) (int, error) {
+	if f.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	return 0, io.EOF
+}
+
+func (f *tokenMapFile) Close() error {
+	return nil
+}
+
+func (f *tokenMapFile) Name() string {
+	return f.name
+}
+
+func (f *tokenMapFile) Size() int64 {
+	return 0
+}
+
+func (f *tokenMapFile) Mode() fs.FileMode {
+	if f.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (f *tokenMapFile) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (f *tokenMapFile) IsDir() bool {
+	return f.dir
+}
+
+func (f *tokenMapFile) Sys() interface{} {
+	return nil
+}
@@ -16,6 +16,9 @@ package preprocess
 
 import (
 	"io"
+
+	"github.com/hajimehoshi/goc/internal/diag"
+	"github.com/hajimehoshi/goc/internal/srcpos"
 )
 
 type source struct {
@@ -24,6 +27,16 @@ type source struct {
 
 	filename string
 	lineno   int
+
+	// lineStart is the offset of the start of the current line.
+	lineStart int
+
+	// trigraphs indicates whether trigraph sequences are replaced.
+	trigraphs bool
+
+	// warnings is the warnings about the trigraph sequences that are read
+	// while trigraphs are disabled.
+	warnings []*diag.Diagnostic
 }
 
 func newSource(src []byte, filename string) *source {
@@ -33,7 +46,58 @@ func newSource(src []byte, filename string) *source {
 	return &source{
 		src:      src,
 		filename: filename,
+		lineno:   1,
+	}
+}
+
+// trigraphs is the map of the third characters of trigraph sequences and the
+// characters they are replaced with.
+//
+// "5.2.1.1 Trigraph sequences" [spec]
+var trigraphs = map[byte]byte{
+	'=':  '#',
+	'(':  '[',
+	'/':  '\\',
+	')':  ']',
+	'\'': '^',
+	'<':  '{',
+	'!':  '|',
+	'>':  '}',
+	'-':  '~',
+}
+
+// isTrigraph reports whether the offset i of the rest of the source begins
+// with a trigraph sequence.
+func (s *source) isTrigraph(i int) bool {
+	if i+2 >= len(s.src) || s.src[i] != '?' || s.src[i+1] != '?' {
+		return false
+	}
+	_, ok := trigraphs[s.src[i+2]]
+	return ok
+}
+
+// char returns the character at the offset i of the rest of the source, and
+// its length in bytes. A trigraph sequence is read as one character if
+// trigraphs are enabled.
+func (s *source) char(i int) (byte, int) {
+	if s.trigraphs && s.isTrigraph(i) {
+		return trigraphs[s.src[i+2]], 3
+	}
+	return s.src[i], 1
+}
+
+// splice returns the length in bytes of the line splice, a backslash
+// followed by a new-line character, at the offset i of the rest of the
+// source, or 0 if there is no line splice.
+func (s *source) splice(i int) int {
+	if i >= len(s.src) {
+		return 0
 	}
+	b, n := s.char(i)
+	if b != '\\' || i+n >= len(s.src) || s.src[i+n] != '\n' {
+		return 0
+	}
+	return n + 1
 }
 
 func (s *source) ReadByte() (byte, error) {
@@ -41,45 +105,38 @@ func (s *source) ReadByte() (byte, error) {
 		if len(s.src) == 0 {
 			return 0, io.EOF
 		}
-		b := s.src[0]
-		s.src = s.src[1:]
-		s.pos++
-		if b == '\n' {
+		if n := s.splice(0); n > 0 {
+			s.src = s.src[n:]
+			s.pos += n
 			s.lineno++
+			s.lineStart = s.pos
+			continue
 		}
 
-		if b != '\\' {
-			return b, nil
-		}
-		if len(s.src) == 0 {
-			return b, nil
+		if !s.trigraphs && s.isTrigraph(0) {
+			s.warnings = append(s.warnings, diag.Warningf(s.Position(), "trigraph %s ignored", s.src[:3]))
 		}
-		if s.src[0] != '\n' {
-			return b, nil
+		b, n := s.char(0)
+		s.src = s.src[n:]
+		s.pos += n
+		if b == '\n' {
+			s.lineno++
+			s.lineStart = s.pos
 		}
-		s.src = s.src[1:]
-		s.pos++
-		s.lineno++
+		return b, nil
 	}
 }
 
 func (s *source) Peek(n int) ([]byte, error) {
 	bs := []byte{}
-	for i := 0; len(bs) < n && i < len(s.src); i++ {
-		b := s.src[i]
-		if b != '\\' {
-			bs = append(bs, b)
-			continue
-		}
-		if len(s.src) <= i+1 {
-			bs = append(bs, b)
-			continue
-		}
-		if s.src[i+1] != '\n' {
-			bs = append(bs, b)
+	for i := 0; len(bs) < n && i < len(s.src); {
+		if m := s.splice(i); m > 0 {
+			i += m
 			continue
 		}
-		i++
+		b, m := s.char(i)
+		bs = append(bs, b)
+		i += m
 	}
 	if len(bs) < n {
 		return bs, io.EOF
@@ -99,6 +156,30 @@ func (s *source) Pos() int {
 	return s.pos
 }
 
+// Position returns the position of the next byte to read.
+func (s *source) Position() srcpos.Position {
+	pos := s.pos
+	lineno := s.lineno
+	lineStart := s.lineStart
+	// Skip line splices, which ReadByte skips.
+	for i := 0; ; {
+		n := s.splice(i)
+		if n == 0 {
+			break
+		}
+		i += n
+		pos += n
+		lineno++
+		lineStart = pos
+	}
+	return srcpos.Position{
+		Filename: s.filename,
+		Line:     lineno,
+		Column:   pos - lineStart + 1,
+		Offset:   pos,
+	}
+}
+
 type bufSource struct {
 	src *source
 	raw []byte
@@ -14,9 +14,19 @@
 
 package preprocess
 
+import (
+	"github.com/hajimehoshi/goc/internal/diag"
+)
+
+// stringConcatter concatenates adjacent string literal tokens.
+//
+// "6.4.5 String literals" [spec]
 type stringConcatter struct {
 	src PPTokenReader
 	buf *Token
+
+	// diags is the collector of the recoverable errors.
+	diags *diag.Collector
 }
 
 func (s *stringConcatter) NextPPToken() (*Token, error) {
@@ -34,7 +44,7 @@ func (s *stringConcatter) NextPPToken() (*Token, error) {
 		return t, nil
 	}
 
-	str := t
+	strs := []*Token{t}
 	for {
 		t, err := s.src.NextPPToken()
 		if err != nil {
@@ -42,13 +52,53 @@ func (s *stringConcatter) NextPPToken() (*Token, error) {
 		}
 		if t.Type != StringLiteral {
 			s.buf = t
-			return str, nil
+			break
+		}
+		strs = append(strs, t)
+	}
+	if len(strs) == 1 {
+		return strs[0], nil
+	}
+
+	// "If any of the tokens has an encoding prefix, the resulting multibyte
+	// character sequence is treated as having the same prefix; otherwise, it
+	// is treated as a character string literal. Whether differently-prefixed
+	// wide string literal tokens can be concatenated and, if so, the
+	// treatment of the resulting multibyte character sequence are
+	// implementation-defined." [spec]
+	// Like GCC, differently-prefixed string literals cannot be concatenated.
+	prefix := NoPrefix
+	for _, t := range strs {
+		if t.Prefix == NoPrefix {
+			continue
+		}
+		if prefix == NoPrefix {
+			prefix = t.Prefix
+			continue
+		}
+		if t.Prefix != prefix {
+			s.diags.Report(errorAt(t, "unsupported non-standard concatenation of string literals"))
+		}
+	}
+
+	// Copy the token not to modify the original token.
+	str := *strs[0]
+	str.Prefix = prefix
+	str.Units = nil
+	str.Raw = ""
+	for _, t := range strs {
+		units, err := reencodeString(t, prefix)
+		if err != nil {
+			s.diags.Report(errorAt(t, "%s", err))
+			units = nil
 		}
-		str.Val += t.Val
+		str.Units = append(str.Units, units...)
 		if str.Raw == "" {
 			str.Raw += t.Raw
 		} else {
 			str.Raw += " " + t.Raw
 		}
 	}
+	str.Val = prefix.decode(str.Units)
+	return &str, nil
 }
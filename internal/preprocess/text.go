@@ -0,0 +1,240 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preprocess
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+// TextOptions represents options for WriteText.
+type TextOptions struct {
+	// NoLineMarkers indicates whether linemarkers are omitted, like -P.
+	NoLineMarkers bool
+}
+
+// maxBlankLines is the maximum number of blank lines written to keep the line
+// structure. A linemarker is written instead for more lines.
+const maxBlankLines = 8
+
+// WriteText preprocesses the file of path with the options, and writes the
+// result to w as source text like cc -E. opts and textOpts can be nil.
+//
+// The tokens are written at their lines in the source, and a space is
+// inserted only where the source has white-spaces or where adjacent tokens
+// would be pasted otherwise. Linemarkers in the form of
+//
+//	# linenum "filename" flags
+//
+// are written when files are entered or left, as GCC does. The flag 1 means
+// entering a new file, 2 means returning to a file, and 3 means the file is a
+// system header.
+//
+// Pragmas except #pragma once are written as #pragma directives in their own
+// lines, even if they are given by the _Pragma operator.
+//
+// Like PreprocessWithOptions, the text is written even after recoverable
+// errors, and the error of diag.List including all the errors is returned.
+func WriteText(w io.Writer, path string, tokens map[string][]*Token, opts *Options, textOpts *TextOptions) error {
+	tw := &textWriter{
+		w:        bufio.NewWriter(w),
+		lineHead: true,
+		systems:  []bool{false},
+	}
+	if textOpts != nil {
+		tw.noLineMarkers = textOpts.NoLineMarkers
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	// Pragmas are written for the compiler.
+	o := *opts
+	o.KeepPragmas = true
+	p, err := newPreprocessor(path, tokens, &o, tw)
+	if err != nil {
+		return err
+	}
+
+	tw.marker(1, path, "")
+	if len(opts.ForceIncludes) > 0 {
+		tw.marker(1, commandLineFilename, "")
+	}
+	for {
+		t, err := p.NextPPToken()
+		if err != nil {
+			p.diags.ReportError(err)
+			break
+		}
+		if t.Type == EOF {
+			break
+		}
+		tw.writeToken(t)
+	}
+	if !tw.lineHead {
+		tw.newLine()
+	}
+	if err := tw.w.Flush(); err != nil && tw.err == nil {
+		tw.err = err
+	}
+	if tw.err != nil {
+		return tw.err
+	}
+	if opts.Macros != nil {
+		opts.Macros.set(p.macros)
+	}
+	return p.diags.Err()
+}
+
+// textWriter writes preprocessed tokens as source text.
+type textWriter struct {
+	w             *bufio.Writer
+	noLineMarkers bool
+
+	// filename and line are the source location of the current output line.
+	filename string
+	line     int
+
+	// lineHead indicates whether nothing is written in the current output
+	// line.
+	lineHead bool
+
+	// prev is the last written token.
+	prev *Token
+
+	// systems is the include stack, which indicates whether each file is a
+	// system header. The current file comes last.
+	systems []bool
+
+	// err is the first error in writing.
+	err error
+}
+
+func (w *textWriter) write(s string) {
+	if w.err != nil {
+		return
+	}
+	if _, err := w.w.WriteString(s); err != nil {
+		w.err = err
+	}
+}
+
+func (w *textWriter) newLine() {
+	w.write("\n")
+	w.line++
+	w.lineHead = true
+}
+
+// marker writes a linemarker, and moves the current location to line in
+// filename.
+func (w *textWriter) marker(line int, filename string, flags string) {
+	if !w.lineHead {
+		w.newLine()
+	}
+	if !w.noLineMarkers {
+		w.write(fmt.Sprintf("# %d %s%s\n", line, newStringLiteral(filename).Raw, flags))
+	}
+	w.filename = filename
+	w.line = line
+}
+
+// systemFlag returns the linemarker flag for the current file.
+func (w *textWriter) systemFlag() string {
+	if w.systems[len(w.systems)-1] {
+		return " 3"
+	}
+	return ""
+}
+
+func (w *textWriter) enterFile(path string, system bool) {
+	w.systems = append(w.systems, system)
+	w.marker(1, path, " 1"+w.systemFlag())
+}
+
+func (w *textWriter) leaveFile(at srcpos.Position) {
+	w.systems = w.systems[:len(w.systems)-1]
+	// The file is returned at the line after the #include directive.
+	w.marker(at.Line+1, at.Filename, " 2"+w.systemFlag())
+}
+
+// moveTo moves the current output line to pos by writing new-lines or a
+// linemarker.
+func (w *textWriter) moveTo(pos srcpos.Position) {
+	if !w.lineHead {
+		w.newLine()
+	}
+	if w.noLineMarkers {
+		w.filename = pos.Filename
+		w.line = pos.Line
+		return
+	}
+	if pos.Filename != w.filename || pos.Line < w.line || pos.Line-w.line > maxBlankLines {
+		w.marker(pos.Line, pos.Filename, "")
+		return
+	}
+	for w.line < pos.Line {
+		w.newLine()
+	}
+}
+
+func (w *textWriter) writeToken(t *Token) {
+	pos := t.Position()
+	if t.Type == PragmaDirective {
+		// A pragma is written in its own line.
+		w.moveTo(pos)
+		w.write(t.Raw)
+		w.newLine()
+		w.prev = nil
+		return
+	}
+	if pos.Filename != w.filename || pos.Line != w.line {
+		w.moveTo(pos)
+	}
+	if w.lineHead {
+		// Keep the indentation.
+		if pos.Column > 1 {
+			w.write(strings.Repeat(" ", pos.Column-1))
+		}
+	} else if !t.Adjacent || wouldPaste(w.prev, t) {
+		w.write(" ")
+	}
+	w.write(t.Raw)
+	// A block comment can have new-lines.
+	w.line += strings.Count(t.Raw, "\n")
+	w.lineHead = false
+	w.prev = t
+}
+
+// wouldPaste reports whether the tokens lhs and rhs would be read as
+// different tokens if they were written without a space, e.g., '+' and '+'
+// produced by different macros.
+func wouldPaste(lhs, rhs *Token) bool {
+	if lhs == nil || lhs.Type == Comment || rhs.Type == Comment {
+		return false
+	}
+	t := &tokenizer{
+		src: newSource([]byte(lhs.Raw+rhs.Raw), ""),
+	}
+	for _, e := range []*Token{lhs, rhs} {
+		tk, err := t.NextPPToken()
+		if err != nil || tk.Raw != e.Raw {
+			return true
+		}
+	}
+	return false
+}
@@ -15,7 +15,9 @@
 package preprocess
 
 import (
+	"github.com/hajimehoshi/goc/internal/diag"
 	"github.com/hajimehoshi/goc/internal/lex"
+	"github.com/hajimehoshi/goc/internal/srcpos"
 )
 
 type TokenType int
@@ -57,9 +59,27 @@ const (
 	// "each non-white-space character that cannot be one of the above" [spec]
 	Other
 
+	// Comment represents a comment. Comments are kept only when the source is
+	// tokenized by TokenizeWithComments.
+	Comment
+
 	// Param represents a place holder for macro parameters.
 	Param
 
+	// VaOpt represents a place holder for __VA_OPT__ in macro replacement
+	// lists.
+	VaOpt
+
+	// Placemarker represents a placemarker preprocessing token that is used
+	// temporarily while processing ## operators.
+	Placemarker
+
+	// PragmaDirective represents a pragma passed through for the compiler.
+	// Raw is the pragma as a #pragma directive, even if the pragma is given
+	// by the _Pragma operator. PragmaDirective tokens are kept only when
+	// Options.KeepPragmas is true.
+	PragmaDirective
+
 	EOF
 )
 
@@ -128,8 +148,16 @@ func (t TokenType) String() string {
 		return "##"
 	case Other:
 		return "other"
+	case Comment:
+		return "comment"
 	case Param:
 		return "param"
+	case VaOpt:
+		return "__VA_OPT__"
+	case Placemarker:
+		return "placemarker"
+	case PragmaDirective:
+		return "pragma"
 	case EOF:
 		return "EOF"
 	}
@@ -142,9 +170,44 @@ type Token struct {
 	Raw      string
 	Adjacent bool
 
-	ParamIndex   int
-	ParamHash    bool
+	// Pos is the spelling location of the token. For a token produced by macro
+	// expansion, this is the location in the macro definition or in the
+	// macro arguments.
+	Pos srcpos.Position
+
+	// ExpansionPos is the location of the outermost macro invocation that
+	// produced the token. This is invalid if the token is not produced by
+	// macro expansion.
+	ExpansionPos srcpos.Position
+
+	// Prefix is the encoding prefix of a character constant or a string
+	// literal.
+	Prefix EncodingPrefix
+
+	// Units is the value of a character constant or a string literal as the
+	// code units of the encoding of Prefix. The terminating null character of
+	// a string literal is not included.
+	//
+	// Val is the same value as a string. See also EncodingPrefix.
+	Units []uint32
+
+	ParamIndex int
+	ParamHash  bool
+
+	// ExpandedFrom is the hide set of the token, that is the names of the
+	// macros whose expansions produced the token. The token is not expanded
+	// again as one of these macros.
 	ExpandedFrom map[string]struct{}
+
+	// expansions is the macro-expansion backtrace of the token produced by
+	// macro expansion. The outermost expansion comes first, and each frame is
+	// the location in the replacement list of the named macro.
+	expansions []diag.Frame
+
+	// err is the error of the token found by tokenization, like an invalid
+	// escape sequence or the quote of an unterminated character constant.
+	// This is reported only when the token is not in a skipped group.
+	err error
 }
 
 func (t *Token) String() string {
@@ -157,3 +220,13 @@ func (t *Token) String() string {
 		return t.Raw
 	}
 }
+
+// Position returns the location where the token appears in the source file
+// after macro expansion. This is the expansion location if the token is
+// produced by macro expansion, or the spelling location otherwise.
+func (t *Token) Position() srcpos.Position {
+	if t.ExpansionPos.IsValid() {
+		return t.ExpansionPos
+	}
+	return t.Pos
+}
@@ -15,11 +15,12 @@
 package preprocess
 
 import (
-	"fmt"
 	"io"
 	"strings"
 
+	"github.com/hajimehoshi/goc/internal/diag"
 	"github.com/hajimehoshi/goc/internal/lex"
+	"github.com/hajimehoshi/goc/internal/srcpos"
 )
 
 type PPTokenReader interface {
@@ -50,11 +51,34 @@ func nextExpected(t PPTokenReader, expected ...TokenType) (*Token, error) {
 		}
 	}
 
-	s := []string{}
-	for _, e := range expected {
-		s = append(s, e.String())
+	s := ""
+	for i, e := range expected {
+		switch {
+		case i == 0:
+		case i == len(expected)-1:
+			s += " or "
+		default:
+			s += ", "
+		}
+		s += describeTokenType(e)
+	}
+	found := "end of line"
+	if tk.Type != '\n' && tk.Type != EOF {
+		found = "'" + tk.Raw + "'"
+	}
+	return nil, errorAt(tk, "expected %s but found %s", s, found)
+}
+
+// describeTokenType returns the description of the token type t for error
+// messages. A punctuator is quoted like ')'.
+func describeTokenType(t TokenType) string {
+	switch t {
+	case '\n':
+		return "end of line"
+	case HeaderName, Identifier, PPNumber, CharacterConstant, StringLiteral:
+		return t.String()
 	}
-	return nil, fmt.Errorf("preprocess: expected %s but %s", strings.Join(s, ","), tk.Type)
+	return "'" + t.String() + "'"
 }
 
 type tokenizer struct {
@@ -64,22 +88,40 @@ type tokenizer struct {
 	// -1 means header-name is no longer expected in the current line.
 	// 0 means the start of the new line (just after '\n' or the initial state).
 	// 1 means the start of the line of preprocessing (just after '#').
-	// 2 means header-name is expected (just after '#include').
+	// 2 means header-name is expected (just after '#include', '#include_next'
+	// or '#embed').
+	// 3 means the rest of the line is a message of '#error' or '#warning'.
 	ppstate int
 
 	isSpace  bool
 	wasSpace bool
+
+	// keepComments indicates whether comments are returned as Comment tokens.
+	keepComments bool
+
+	// diags is the collector of the recoverable errors. If diags is nil, the
+	// first error is returned.
+	diags *diag.Collector
 }
 
 func (t *tokenizer) headerNameExpected() bool {
 	return t.ppstate == 2
 }
 
+// messageExpected reports whether the current line is a message of #error or
+// #warning. A message can include unmatched quotes like "don't".
+func (t *tokenizer) messageExpected() bool {
+	return t.ppstate == 3
+}
+
 func (t *tokenizer) next() (*Token, error) {
 	var tk *Token
+	var pos srcpos.Position
 	for {
 		var err error
+		pos = t.src.Position()
 		tk, err = t.nextImpl(t.src)
+		t.reportWarnings()
 		if tk == nil && err == nil {
 			continue
 		}
@@ -87,26 +129,43 @@ func (t *tokenizer) next() (*Token, error) {
 			if err == io.EOF && tk != nil {
 				panic("not reached")
 			}
-			return nil, err
+			if t.diags == nil {
+				return nil, err
+			}
+			tk, err = t.recover(pos, err)
+			if err != nil {
+				return nil, err
+			}
+			if tk == nil {
+				continue
+			}
 		}
 		break
 	}
 
 	tk.Adjacent = !t.wasSpace
+	tk.Pos = pos
 
-	switch tk.Type {
-	case '\n':
+	switch {
+	case tk.Type == Comment:
+		// A comment does not change the context.
+	case tk.Type == '\n':
 		t.ppstate = 0
-	case '#':
+	case t.messageExpected():
+		// The message continues until the end of the line.
+	case tk.Type == '#':
 		if t.ppstate == 0 {
 			t.ppstate = 1
 		} else {
 			t.ppstate = -1
 		}
-	case Identifier:
-		if t.ppstate == 1 && tk.Raw == "include" {
+	case tk.Type == Identifier && t.ppstate == 1:
+		switch tk.Raw {
+		case "include", "include_next", "embed":
 			t.ppstate = 2
-		} else {
+		case "error", "warning":
+			t.ppstate = 3
+		default:
 			t.ppstate = -1
 		}
 	default:
@@ -116,6 +175,196 @@ func (t *tokenizer) next() (*Token, error) {
 	return tk, nil
 }
 
+// recover reports the error err at pos, and skips the rest of the line.
+// If the new-line character is already consumed, recover returns a new-line
+// token instead.
+func (t *tokenizer) recover(pos srcpos.Position, err error) (*Token, error) {
+	d, ok := err.(*diag.Diagnostic)
+	if !ok {
+		d = diag.Errorf(pos, "%s", strings.TrimPrefix(err.Error(), "lex: "))
+	}
+	t.diags.Report(d)
+	if t.src.Position().Line > pos.Line && t.src.Position().Column == 1 {
+		return &Token{
+			Type: '\n',
+			Val:  "\n",
+			Raw:  "\n",
+		}, nil
+	}
+	for {
+		bs, err := t.src.Peek(1)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(bs) < 1 || bs[0] == '\n' {
+			return nil, nil
+		}
+		mustDiscard(t.src, 1)
+	}
+}
+
+// reportWarnings reports the warnings of the source. The warnings are
+// discarded if the tokenizer does not have the collector.
+func (t *tokenizer) reportWarnings() {
+	if t.diags != nil {
+		for _, d := range t.src.warnings {
+			t.diags.Report(d)
+		}
+	}
+	t.src.warnings = nil
+}
+
+// comment returns a Comment token of raw if comments are kept, or nil
+// otherwise.
+//
+// "Each comment is replaced by one space character." [spec]
+func (t *tokenizer) comment(raw string) *Token {
+	t.isSpace = true
+	if !t.keepComments {
+		return nil
+	}
+	return &Token{
+		Type: Comment,
+		Val:  raw,
+		Raw:  raw,
+	}
+}
+
+// digraph reads the digraph raw, which is the alternative spelling of the
+// punctuator val.
+//
+// "In all aspects of the language, the six tokens <: :> <% %> %: %:%:
+// behave, respectively, the same as the six tokens [ ] { } # ## except for
+// their spelling." [spec]
+func digraph(src *source, raw string, val string) *Token {
+	mustDiscard(src, len(raw))
+	typ := TokenType(val[0])
+	if val == "##" {
+		typ = HashHash
+	}
+	return &Token{
+		Type: typ,
+		Val:  val,
+		Raw:  raw,
+	}
+}
+
+// unmatchedQuote reads a quote as an Other token.
+//
+// "If a ' or a " character matches the last category, the behavior is
+// undefined." [spec]
+// In a message of #error or #warning, a quote is read as an Other token so
+// that the message can have unmatched quotes.
+func unmatchedQuote(src *source) *Token {
+	bs, _ := src.Peek(1)
+	q := string(bs[:1])
+	mustDiscard(src, 1)
+	return &Token{
+		Type: Other,
+		Val:  q,
+		Raw:  q,
+	}
+}
+
+// literal reads a character constant or a string literal with the encoding
+// prefix.
+//
+// If the literal is invalid, the literal is read as it is with the error,
+// which is reported later unless the token is in a skipped group. If the
+// literal is not terminated in the line, only the quote is read as an Other
+// token as GCC does. In this case, literal returns nil if there is an encoding
+// prefix, which should be read as an identifier instead.
+func literal(src *source, prefix EncodingPrefix) (*Token, error) {
+	s := *src
+	tk, err := readLiteral(src, prefix)
+	if err == nil {
+		return tk, nil
+	}
+	*src = s
+	if _, ok := err.(*lex.UnterminatedError); !ok {
+		if tk := invalidLiteral(src, prefix); tk != nil {
+			tk.err = err
+			return tk, nil
+		}
+		*src = s
+	}
+	if prefix != NoPrefix {
+		return nil, nil
+	}
+	quote, _ := src.Peek(1)
+	tk = unmatchedQuote(src)
+	tk.err = &lex.UnterminatedError{Quote: quote[0]}
+	return tk, nil
+}
+
+// invalidLiteral reads a character constant or a string literal with the
+// encoding prefix without interpreting the escape sequences. invalidLiteral
+// returns nil if the literal is not terminated in the line.
+func invalidLiteral(src *source, prefix EncodingPrefix) *Token {
+	buf := newBufSource(src)
+	mustDiscard(buf, len(prefix.String()))
+	quote, err := buf.ReadByte()
+	if err != nil {
+		return nil
+	}
+	for {
+		b, err := buf.ReadByte()
+		if err != nil || b == '\n' {
+			return nil
+		}
+		if b == quote {
+			break
+		}
+		if b != '\\' {
+			continue
+		}
+		if b, err := buf.ReadByte(); err != nil || b == '\n' {
+			return nil
+		}
+	}
+	typ := StringLiteral
+	if quote == '\'' {
+		typ = CharacterConstant
+	}
+	return &Token{
+		Type:   typ,
+		Val:    buf.Buf(),
+		Raw:    buf.Buf(),
+		Prefix: prefix,
+	}
+}
+
+// readHeaderName reads a header name.
+//
+// If the header name is not terminated in the line, only the first character
+// is read with the error, which is reported later unless the token is in a
+// skipped group.
+func readHeaderName(src *source) *Token {
+	s := *src
+	buf := newBufSource(src)
+	val, err := lex.ReadHeaderName(buf)
+	if err == nil {
+		return &Token{
+			Type: HeaderName,
+			Val:  val,
+			Raw:  buf.Buf(),
+		}
+	}
+	*src = s
+	bs, _ := src.Peek(1)
+	mustDiscard(src, 1)
+	typ := TokenType(bs[0])
+	if bs[0] == '"' {
+		typ = Other
+	}
+	return &Token{
+		Type: typ,
+		Val:  string(bs[:1]),
+		Raw:  string(bs[:1]),
+		err:  err,
+	}
+}
+
 func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 	bs, err := src.Peek(3)
 	if err != nil && err != io.EOF {
@@ -205,6 +454,7 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 			switch bs[1] {
 			case '/':
 				// Line comment
+				raw := "//"
 				mustDiscard(src, 2)
 				for {
 					bs, err := src.Peek(1)
@@ -217,11 +467,14 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 					if bs[0] == '\n' {
 						break
 					}
+					raw += string(bs[:1])
 					mustDiscard(src, 1)
 				}
-				return nil, nil
+				return t.comment(raw), nil
 			case '*':
 				// Block comment
+				raw := "/*"
+				pos := src.Position()
 				mustDiscard(src, 2)
 				for {
 					bs, err := src.Peek(2)
@@ -229,15 +482,18 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 						return nil, err
 					}
 					if len(bs) <= 1 {
-						return nil, fmt.Errorf("preprocess: unclosed block comment")
+						mustDiscard(src, len(bs))
+						return nil, diag.Errorf(pos, "unterminated comment")
 					}
 					if bs[0] == '*' && bs[1] == '/' {
+						raw += "*/"
 						mustDiscard(src, 2)
 						break
 					}
+					raw += string(bs[:1])
 					mustDiscard(src, 1)
 				}
-				return nil, nil
+				return t.comment(raw), nil
 			case '=':
 				mustDiscard(src, 2)
 				return &Token{
@@ -248,13 +504,27 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 			}
 		}
 	case '%':
-		if len(bs) >= 2 && bs[1] == '=' {
-			mustDiscard(src, 2)
-			return &Token{
-				Type: ModEq,
-				Val:  string(bs[:2]),
-				Raw:  string(bs[:2]),
-			}, nil
+		if len(bs) >= 2 {
+			switch bs[1] {
+			case '=':
+				mustDiscard(src, 2)
+				return &Token{
+					Type: ModEq,
+					Val:  string(bs[:2]),
+					Raw:  string(bs[:2]),
+				}, nil
+			case '>':
+				return digraph(src, "%>", "}"), nil
+			case ':':
+				bs, err := src.Peek(4)
+				if err != nil && err != io.EOF {
+					return nil, err
+				}
+				if string(bs) == "%:%:" {
+					return digraph(src, "%:%:", "##"), nil
+				}
+				return digraph(src, "%:", "#"), nil
+			}
 		}
 	case '=':
 		if len(bs) >= 2 && bs[1] == '=' {
@@ -267,16 +537,15 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 		}
 	case '<':
 		if t.headerNameExpected() {
-			buf := newBufSource(src)
-			val, err := lex.ReadHeaderName(buf)
-			if err != nil {
-				return nil, err
+			return readHeaderName(src), nil
+		}
+		if len(bs) >= 2 {
+			switch bs[1] {
+			case ':':
+				return digraph(src, "<:", "["), nil
+			case '%':
+				return digraph(src, "<%", "{"), nil
 			}
-			return &Token{
-				Type: HeaderName,
-				Val:  val,
-				Raw:  buf.Buf(),
-			}, nil
 		}
 		if len(bs) >= 2 && bs[1] == '<' {
 			if len(bs) >= 3 && bs[2] == '=' {
@@ -294,6 +563,14 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 				Raw:  string(bs[:2]),
 			}, nil
 		}
+		if len(bs) >= 2 && bs[1] == '=' {
+			mustDiscard(src, 2)
+			return &Token{
+				Type: Le,
+				Val:  string(bs[:2]),
+				Raw:  string(bs[:2]),
+			}, nil
+		}
 	case '>':
 		if len(bs) >= 2 && bs[1] == '>' {
 			if len(bs) >= 3 && bs[2] == '=' {
@@ -311,6 +588,14 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 				Raw:  string(bs[:2]),
 			}, nil
 		}
+		if len(bs) >= 2 && bs[1] == '=' {
+			mustDiscard(src, 2)
+			return &Token{
+				Type: Ge,
+				Val:  string(bs[:2]),
+				Raw:  string(bs[:2]),
+			}, nil
+		}
 	case '&':
 		if len(bs) >= 2 {
 			switch bs[1] {
@@ -368,41 +653,20 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 			}, nil
 		}
 	case '\'':
-		// Char literal
-		buf := newBufSource(src)
-		val, err := lex.ReadChar(buf)
-		if err != nil {
-			return nil, err
+		if t.messageExpected() {
+			return unmatchedQuote(src), nil
 		}
-		return &Token{
-			Type: CharacterConstant,
-			Val:  string(val),
-			Raw:  buf.Buf(),
-		}, nil
+		// Char literal
+		return literal(src, NoPrefix)
 	case '"':
 		if t.headerNameExpected() {
-			buf := newBufSource(src)
-			val, err := lex.ReadHeaderName(buf)
-			if err != nil {
-				return nil, err
-			}
-			return &Token{
-				Type: HeaderName,
-				Val:  val,
-				Raw:  buf.Buf(),
-			}, nil
+			return readHeaderName(src), nil
 		}
-		// String literal
-		buf := newBufSource(src)
-		val, err := lex.ReadString(buf)
-		if err != nil {
-			return nil, err
+		if t.messageExpected() {
+			return unmatchedQuote(src), nil
 		}
-		return &Token{
-			Type: StringLiteral,
-			Val:  val,
-			Raw:  buf.Buf(),
-		}, nil
+		// String literal
+		return literal(src, NoPrefix)
 	case '.':
 		if len(bs) >= 2 {
 			if bs[1] == '.' && len(bs) >= 3 && bs[2] == '.' {
@@ -446,18 +710,44 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 				Raw:  string(bs[:2]),
 			}, nil
 		}
-	case ';', '(', ')', ',', '{', '}', '[', ']', '?', ':', '~':
+	case ':':
+		if len(bs) >= 2 && bs[1] == '>' {
+			return digraph(src, ":>", "]"), nil
+		}
+	case ';', '(', ')', ',', '{', '}', '[', ']', '?', '~':
 		// Single character token
 	default:
-		if lex.IsNondigit(b) {
-			name, err := lex.ReadIdentifier(src)
+		if lex.StartsWithIdentifier(src) {
+			// A character constant or a string literal with an encoding
+			// prefix
+			if prefix, ok := literalPrefix(bs); ok && !t.messageExpected() {
+				tk, err := literal(src, prefix)
+				if tk != nil || err != nil {
+					return tk, err
+				}
+				// The prefix is read as an identifier.
+			}
+			// The value is normalized while the spelling is kept.
+			// An identifier with an invalid universal character name is
+			// read with the error, which is reported later unless the token
+			// is in a skipped group.
+			buf := newBufSource(src)
+			name, err := lex.ReadIdentifier(buf)
 			if err != nil {
-				return nil, err
+				if buf.Buf() == "" {
+					return nil, err
+				}
+				return &Token{
+					Type: Identifier,
+					Val:  buf.Buf(),
+					Raw:  buf.Buf(),
+					err:  err,
+				}, nil
 			}
 			return &Token{
 				Type: Identifier,
 				Val:  name,
-				Raw:  name,
+				Raw:  buf.Buf(),
 			}, nil
 		}
 
@@ -480,6 +770,9 @@ func (t *tokenizer) nextImpl(src *source) (*Token, error) {
 			if lex.IsWhitespace(b) {
 				break
 			}
+			if len(val) > 0 && lex.StartsWithIdentifier(src) {
+				break
+			}
 			mustDiscard(src, 1)
 			val = append(val, b)
 		}
@@ -513,20 +806,72 @@ func (t *tokenizer) NextPPToken() (*Token, error) {
 	}
 }
 
+// TokenizeOptions represents the options of tokenization.
+type TokenizeOptions struct {
+	// KeepComments indicates whether comments are kept as Comment tokens.
+	KeepComments bool
+
+	// Trigraphs indicates whether trigraph sequences like ??= are replaced.
+	// If Trigraphs is false, trigraph sequences are left as they are with
+	// warnings.
+	Trigraphs bool
+}
+
+// Tokenize tokenizes the source into preprocessing tokens.
+//
+// Tokenization continues after recoverable errors. In this case, the tokens are
+// returned with an error of diag.List including all the errors.
+//
+// The errors of the preprocessing tokens that can be in a skipped group, like
+// an invalid escape sequence, an invalid universal character name in an
+// identifier or an unterminated header name, are not returned. The tokens are
+// tokenized with the errors, which are reported by the preprocessor unless the
+// tokens are in skipped groups. The quote of an unterminated character
+// constant or string literal is tokenized as an Other token.
 func Tokenize(src []byte, filename string) ([]*Token, error) {
+	return TokenizeWithOptions(src, filename, nil)
+}
+
+// TokenizeWithComments is like Tokenize, but comments are kept as Comment
+// tokens.
+func TokenizeWithComments(src []byte, filename string) ([]*Token, error) {
+	return TokenizeWithOptions(src, filename, &TokenizeOptions{
+		KeepComments: true,
+	})
+}
+
+// TokenizeWithOptions is like Tokenize, but with the options. Warnings are not
+// included in the returned error.
+func TokenizeWithOptions(src []byte, filename string, opts *TokenizeOptions) ([]*Token, error) {
+	diags := &diag.Collector{}
+	tks := tokenize(src, filename, opts, diags)
+	return tks, diags.Err()
+}
+
+// tokenize tokenizes the source, and reports the errors and the warnings to
+// diags. tokenize returns nil if tokenization cannot continue.
+func tokenize(src []byte, filename string, opts *TokenizeOptions, diags *diag.Collector) []*Token {
+	if opts == nil {
+		opts = &TokenizeOptions{}
+	}
+	s := newSource(src, filename)
+	s.trigraphs = opts.Trigraphs
 	t := &tokenizer{
-		src: newSource(src, filename),
+		src:          s,
+		diags:        diags,
+		keepComments: opts.KeepComments,
 	}
 	tks := []*Token{}
 	for {
 		tk, err := t.NextPPToken()
 		if err != nil {
-			return nil, err
+			diags.ReportError(err)
+			return nil
 		}
 		if tk.Type == EOF {
 			break
 		}
 		tks = append(tks, tk)
 	}
-	return tks, nil
+	return tks
 }
@@ -264,3 +264,241 @@ func ExampleTokenizeIncludeWithBackslash() {
 	// "ab\c"
 	// (\n)
 }
+
+func ExampleTokenizeRelational() {
+	outputTokens(`a<=b>=c<d>e`)
+	// Output:
+	// a
+	// <=
+	// b
+	// >=
+	// c
+	// <
+	// d
+	// >
+	// e
+	// (\n)
+}
+
+func ExampleTokenizePosition() {
+	tks, err := Tokenize([]byte(`int  x; /* a
+b */ y
+#define \
+  FOO`), "main.c")
+	if err != nil {
+		fmt.Println("error")
+		return
+	}
+
+	for _, t := range tks {
+		fmt.Println(t, t.Pos, t.Pos.Offset)
+	}
+	// Output:
+	// int main.c:1:1 0
+	// x main.c:1:6 5
+	// ; main.c:1:7 6
+	// y main.c:2:6 18
+	// (\n) main.c:2:7 19
+	// # main.c:3:1 20
+	// define main.c:3:2 21
+	// FOO main.c:4:3 32
+	// (\n) main.c:4:6 35
+}
+
+func ExampleTokenizeErrorRecovery() {
+	tks, err := Tokenize([]byte("a \"b\nc 'd\ne /* f"), "main.c")
+	for _, t := range tks {
+		fmt.Println(t.String())
+	}
+	fmt.Println(err)
+	// Output:
+	// a
+	// "
+	// b
+	// (\n)
+	// c
+	// '
+	// d
+	// (\n)
+	// e
+	// (\n)
+	// main.c:3:3: error: unterminated comment
+}
+
+func ExampleTokenizeDigraphs() {
+	tks, err := Tokenize([]byte("<: :> <% %> %: %:%: %:% <::>"), "")
+	if err != nil {
+		fmt.Println("error")
+		return
+	}
+
+	for _, t := range tks {
+		fmt.Println(t.Type, t.Raw)
+	}
+	// Output:
+	// [ <:
+	// ] :>
+	// { <%
+	// } %>
+	// # %:
+	// ## %:%:
+	// # %:
+	// % %
+	// [ <:
+	// ] :>
+	// new-line
+}
+
+func ExampleTokenizeTrigraphs() {
+	src := []byte(`??=define A ??/
+??( ??) ??< ??> ??' ??! ??- "??/"" ???=
+`)
+	tks, err := TokenizeWithOptions(src, "main.c", &TokenizeOptions{
+		Trigraphs: true,
+	})
+	if err != nil {
+		fmt.Println("error")
+		return
+	}
+
+	for _, t := range tks {
+		fmt.Printf("%s %q %s\n", t, t.Raw, t.Pos)
+	}
+	// Output:
+	// # "#" main.c:1:1
+	// define "define" main.c:1:4
+	// A "A" main.c:1:11
+	// [ "[" main.c:2:1
+	// ] "]" main.c:2:5
+	// { "{" main.c:2:9
+	// } "}" main.c:2:13
+	// ^ "^" main.c:2:17
+	// | "|" main.c:2:21
+	// ~ "~" main.c:2:25
+	// "\"" "\"\\\"\"" main.c:2:29
+	// ? "?" main.c:2:36
+	// # "#" main.c:2:37
+	// (\n) "\n" main.c:2:40
+}
+
+func ExampleTokenizeEncodingPrefix() {
+	tks, err := Tokenize([]byte(`"aé" u8"aé" u"aé😀" U"aé😀" L"\x1234" u8'a' u'é' L'\xffffffff' Lx u8 "a"`), "")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, t := range tks {
+		if t.Type == '\n' {
+			continue
+		}
+		fmt.Printf("%s %s %q %x\n", t.Type, t.Raw, t.Prefix, t.Units)
+	}
+	// Output:
+	// string-literal "aé" "" [61 c3 a9]
+	// string-literal u8"aé" "u8" [61 c3 a9]
+	// string-literal u"aé😀" "u" [61 e9 d83d de00]
+	// string-literal U"aé😀" "U" [61 e9 1f600]
+	// string-literal L"\x1234" "L" [1234]
+	// character-constant u8'a' "u8" [61]
+	// character-constant u'é' "u" [e9]
+	// character-constant L'\xffffffff' "L" [ffffffff]
+	// identifier Lx "" []
+	// identifier u8 "" []
+	// string-literal "a" "" [61]
+}
+
+func ExampleTokenCharacterValue() {
+	tks, err := Tokenize([]byte(`'a' '\xff' 'é' 'ab' 'abcde' L'a' L'ab' L'\xffffffff' u'é' U'😀' u8'a'`), "main.c")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, t := range tks {
+		if t.Type != CharacterConstant {
+			continue
+		}
+		v, w := t.CharacterValue()
+		fmt.Printf("%s %s %d\n", t.Raw, v.Type, v.Value)
+		if w != nil {
+			fmt.Println(w)
+		}
+	}
+	// Output:
+	// 'a' int 97
+	// '\xff' int -1
+	// 'é' int 50089
+	// main.c:1:12: warning: multi-character character constant
+	// 'ab' int 24930
+	// main.c:1:17: warning: multi-character character constant
+	// 'abcde' int 1650680933
+	// main.c:1:22: warning: character constant too long for its type
+	// L'a' int 97
+	// L'ab' int 98
+	// main.c:1:35: warning: character constant too long for its type
+	// L'\xffffffff' int -1
+	// u'é' unsigned short 233
+	// U'😀' unsigned int 128512
+	// u8'a' unsigned char 97
+}
+
+func ExampleTokenizeEncodingPrefixError() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `u8"\x100";
+u"\x10000";
+u'😀';
+u8'é';
+U'ab';`,
+	})
+	// Output:
+	// main.c:1:1: error: escape sequence out of range
+	// main.c:2:1: error: escape sequence out of range
+	// main.c:3:1: error: character too large for enclosing character literal type
+	// main.c:4:1: error: character too large for enclosing character literal type
+	// main.c:5:1: error: character constant too long for its type
+}
+
+func ExampleTokenizeUniversalCharacterName() {
+	tks, err := Tokenize([]byte(`caf\u00e9 cafe\u0301 \u5909\u6570 \unope a€ "\u00e9\U0001F600" u"\U0001F600" L'\u00e9'`), "")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, t := range tks {
+		if t.Type == '\n' {
+			continue
+		}
+		fmt.Printf("%s %s %+q %x\n", t.Type, t.Raw, t.Val, t.Units)
+	}
+	// Output:
+	// identifier caf\u00e9 "caf\u00e9" []
+	// identifier cafe\u0301 "caf\u00e9" []
+	// identifier \u5909\u6570 "\u5909\u6570" []
+	// other \ "\\" []
+	// identifier unope "unope" []
+	// identifier a "a" []
+	// other € "\u20ac" []
+	// string-literal "\u00e9\U0001F600" "\u00e9\U0001f600" [c3 a9 f0 9f 98 80]
+	// string-literal u"\U0001F600" "\U0001f600" [d83d de00]
+	// character-constant L'\u00e9' "\u00e9" [e9]
+}
+
+func ExampleTokenizeUniversalCharacterNameError() {
+	outputPreprocessError("main.c", map[string]string{
+		"main.c": `\u0041
+\u0301
+a\u00d7
+"\ud800"
+"\u12"
+'\U00110000'`,
+	})
+	// Output:
+	// main.c:1:1: error: \u0041 is not a valid universal character
+	// main.c:2:1: error: universal character \u0301 is not valid at the start of an identifier
+	// main.c:3:1: error: universal character \u00d7 is not valid in an identifier
+	// main.c:4:1: error: \ud800 is not a valid universal character
+	// main.c:5:1: error: incomplete universal character name \u12
+	// main.c:6:1: error: \U00110000 is not a valid universal character
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package srcpos_test
+
+import (
+	"testing"
+
+	. "github.com/hajimehoshi/goc/internal/srcpos"
+)
+
+func TestPositionString(t *testing.T) {
+	cases := []struct {
+		In  Position
+		Out string
+	}{
+		{Position{}, "-"},
+		{Position{Filename: "a.c"}, "a.c"},
+		{Position{Filename: "a.c", Line: 1}, "a.c:1"},
+		{Position{Filename: "a.c", Line: 2, Column: 3, Offset: 10}, "a.c:2:3"},
+		{Position{Line: 2, Column: 3}, "2:3"},
+	}
+	for _, c := range cases {
+		got := c.In.String()
+		if got != c.Out {
+			t.Errorf("%#v.String(): got: %q, want: %q", c.In, got, c.Out)
+		}
+	}
+}